@@ -0,0 +1,165 @@
+package xtemplate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessLogger writes one line for every request served by an instance.
+type accessLogger struct {
+	format  string
+	trusted []netip.Prefix
+
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestId  string    `json:"request_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Proto      string    `json:"proto"`
+	Pattern    string    `json:"pattern"`
+	Template   string    `json:"template,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Duration   float64   `json:"duration"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// newAccessLogger opens the access log configured by config, or returns nil if
+// the access log is disabled.
+func newAccessLogger(config *Config) (*accessLogger, error) {
+	if config.AccessLog == "" {
+		return nil, nil
+	}
+	l := &accessLogger{format: config.AccessLogFormat}
+	switch l.format {
+	case "":
+		l.format = "json"
+	case "json", "common", "combined":
+	default:
+		return nil, fmt.Errorf("unknown access log format '%s', expected one of: json, common, combined", l.format)
+	}
+	for _, p := range config.TrustedProxies {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			addr, err2 := netip.ParseAddr(p)
+			if err2 != nil {
+				return nil, fmt.Errorf("invalid trusted proxy '%s': %w", p, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		l.trusted = append(l.trusted, prefix.Masked())
+	}
+	if config.AccessLog == "-" {
+		l.w = os.Stdout
+	} else {
+		file, err := os.OpenFile(config.AccessLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		l.w = file
+	}
+	return l, nil
+}
+
+// Close closes the access log file. Reopening the access log on reload allows
+// it to be rotated.
+func (l *accessLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.w == os.Stdout {
+		return nil
+	}
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (l *accessLogger) log(e *accessLogEntry) {
+	var line []byte
+	switch l.format {
+	case "json":
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	case "common", "combined":
+		bytes := "-"
+		if e.Bytes > 0 {
+			bytes = strconv.FormatInt(e.Bytes, 10)
+		}
+		s := fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s`, e.RemoteAddr, e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.Path, e.Proto, e.Status, bytes)
+		if l.format == "combined" {
+			s += fmt.Sprintf(` %s %s`, clfQuote(e.Referer), clfQuote(e.UserAgent))
+		}
+		line = []byte(s + "\n")
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.w.Write(line)
+}
+
+func clfQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// remoteAddr returns the ip address of the client that made the request. If
+// the request came from a trusted proxy, the X-Forwarded-For header is
+// searched from right to left for the first address that is not a trusted
+// proxy.
+func (l *accessLogger) remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !l.isTrusted(addr) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		f := strings.TrimSpace(forwarded[i])
+		if f == "" {
+			continue
+		}
+		a, err := netip.ParseAddr(f)
+		if err != nil {
+			// garbage from an untrusted hop; stop here
+			return host
+		}
+		host = a.String()
+		if !l.isTrusted(a) {
+			break
+		}
+	}
+	return host
+}
+
+func (l *accessLogger) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range l.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package xtemplate
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestAccessLogRemoteAddr(t *testing.T) {
+	l, err := newAccessLogger(&Config{AccessLog: "-", TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "::1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"203.0.113.5:1234", nil, "203.0.113.5"},
+		// untrusted peers can't spoof their address
+		{"203.0.113.5:1234", []string{"1.2.3.4"}, "203.0.113.5"},
+		{"10.1.2.3:80", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"10.1.2.3:80", []string{"1.2.3.4, 10.9.9.9"}, "1.2.3.4"},
+		{"10.1.2.3:80", []string{"1.2.3.4", "192.168.1.1"}, "1.2.3.4"},
+		// the client can prepend anything, only the rightmost untrusted hop counts
+		{"10.1.2.3:80", []string{"6.6.6.6, 1.2.3.4"}, "1.2.3.4"},
+		{"10.1.2.3:80", []string{"1.2.3.4, garbage"}, "10.1.2.3"},
+		{"10.1.2.3:80", []string{"10.4.4.4"}, "10.4.4.4"},
+		{"10.1.2.3:80", nil, "10.1.2.3"},
+		{"[::1]:80", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"[::ffff:10.0.0.1]:80", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"@", []string{"1.2.3.4"}, "@"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		for _, f := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		if got := l.remoteAddr(r); got != test.want {
+			t.Errorf("remoteAddr(%s, %v): expected %s, got %s", test.remote, test.forwarded, test.want, got)
+		}
+	}
+}
+
+func TestAccessLogConfig(t *testing.T) {
+	for _, c := range []Config{
+		{AccessLog: "-", AccessLogFormat: "xml"},
+		{AccessLog: "-", TrustedProxies: []string{"not an ip"}},
+		{AccessLog: filepath.Join(t.TempDir(), "missing", "access.log")},
+	} {
+		if _, err := newAccessLogger(&c); err == nil {
+			t.Errorf("expected an error for config %+v", c)
+		}
+	}
+	if l, err := newAccessLogger(&Config{}); l != nil || err != nil {
+		t.Errorf("expected the access log to be disabled, got %v %v", l, err)
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	e := &accessLogEntry{
+		Time: time.Date(2024, time.March, 5, 14, 3, 9, 0, time.UTC), RemoteAddr: "1.2.3.4", Method: "GET", Path: "/x?y=1",
+		Proto: "HTTP/1.1", Pattern: "GET /x", Status: 200, Bytes: 0, UserAgent: `curl "8"`,
+	}
+	tests := map[string]string{
+		"common":   `1.2.3.4 - - [05/Mar/2024:14:03:09 +0000] "GET /x?y=1 HTTP/1.1" 200 -` + "\n",
+		"combined": `1.2.3.4 - - [05/Mar/2024:14:03:09 +0000] "GET /x?y=1 HTTP/1.1" 200 - "-" "curl \"8\""` + "\n",
+	}
+	for format, want := range tests {
+		var b strings.Builder
+		l := &accessLogger{format: format, w: &b}
+		l.log(e)
+		if b.String() != want {
+			t.Errorf("%s: expected %q, got %q", format, want, b.String())
+		}
+	}
+}
+
+func TestAccessLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	config := New()
+	config.TemplatesFS = fstest.MapFS{"index.html": {Data: []byte("hi")}}
+	config.AccessLog = path
+	instance, _, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if err := instance.Shutdown(config.Ctx); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"pattern":"GET /","template":"/index.html","status":200`) {
+		t.Errorf("unexpected access log line %s", b)
+	}
+}
@@ -0,0 +1,140 @@
+package xtemplate
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AdminHandler returns an http.Handler that exposes the state of the Server
+// and allows controlling it. It should be served on a separate, private listen
+// address. It handles these routes:
+//
+//	GET /         Report the current instance, its stats, route table, static
+//	              files, and the error of the last failed reload as json.
+//	POST /reload  Reload the server, responding with the error if it fails.
+//	POST /drain   Stop the server, waiting for all outstanding requests to
+//	              finish. Requests are answered with 503 Service Unavailable
+//	              until the next successful reload.
+//	GET /metrics  Metrics in the Prometheus text format, if Config.Metrics is
+//	              configured.
+func (x *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", x.adminStatus)
+	x.mutex.Lock()
+	metrics := x.config.Metrics
+	x.mutex.Unlock()
+	if metrics != nil {
+		mux.Handle("GET /metrics", metrics)
+	}
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		x.adminLog().Info("reload requested", slog.String("remote_addr", r.RemoteAddr))
+		x.mutex.Lock()
+		instance, err := x.reload(x.config)
+		x.mutex.Unlock()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"instance": instance.Id()})
+	})
+	mux.HandleFunc("POST /drain", func(w http.ResponseWriter, r *http.Request) {
+		x.adminLog().Info("drain requested", slog.String("remote_addr", r.RemoteAddr))
+		start := time.Now()
+		x.Stop()
+		writeJSON(w, http.StatusOK, map[string]any{"drain_time": time.Since(start).String()})
+	})
+	return mux
+}
+
+func (x *Server) adminLog() *slog.Logger {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.config.Logger.WithGroup("admin")
+}
+
+type adminStatus struct {
+	Instance       *int64         `json:"instance"`
+	ActiveRequests int64          `json:"active_requests"`
+	LoadedAt       time.Time      `json:"loaded_at"`
+	LoadTime       string         `json:"load_time"`
+	Stats          *InstanceStats `json:"stats"`
+	Routes         []adminRoute   `json:"routes"`
+	Files          []adminFile    `json:"files"`
+	// ReloadError is the error of the last reload that failed, even if a
+	// later reload succeeded. ReloadFailed is whether the most recent reload
+	// failed.
+	ReloadError  *adminError `json:"reload_error"`
+	ReloadFailed bool        `json:"reload_failed"`
+}
+
+type adminRoute struct {
+	Pattern  string `json:"pattern"`
+	Template string `json:"template,omitempty"`
+	File     string `json:"file"`
+}
+
+type adminFile struct {
+	Path        string          `json:"path"`
+	Hash        string          `json:"hash"`
+	ContentType string          `json:"content_type"`
+	Encodings   []adminEncoding `json:"encodings"`
+}
+
+type adminEncoding struct {
+	Encoding string    `json:"encoding"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modtime  time.Time `json:"modtime"`
+}
+
+type adminError struct {
+	Error string    `json:"error"`
+	At    time.Time `json:"at"`
+}
+
+func (x *Server) adminStatus(w http.ResponseWriter, r *http.Request) {
+	x.statusMutex.RLock()
+	status := x.status
+	x.statusMutex.RUnlock()
+
+	res := adminStatus{
+		LoadedAt: status.loadedAt,
+		LoadTime: status.loadTime.String(),
+		Stats:    status.stats,
+		Routes:   []adminRoute{},
+		Files:    []adminFile{},
+	}
+	if status.reloadErr != nil {
+		res.ReloadError = &adminError{status.reloadErr.Error(), status.reloadErrAt}
+	}
+	res.ReloadFailed = status.reloadFailed
+	for _, route := range status.routes {
+		res.Routes = append(res.Routes, adminRoute{route.Pattern, route.Template, route.File})
+	}
+	if instance := x.Instance(); instance != nil {
+		id := instance.Id()
+		res.Instance = &id
+		res.ActiveRequests = instance.ActiveRequests()
+		for _, file := range instance.files {
+			f := adminFile{Path: file.identityPath, Hash: file.hash, ContentType: file.contentType}
+			for _, e := range file.encodings {
+				f.Encodings = append(f.Encodings, adminEncoding{e.encoding, e.path, e.size, e.modtime})
+			}
+			res.Files = append(res.Files, f)
+		}
+		slices.SortFunc(res.Files, func(a, b adminFile) int { return strings.Compare(a.Path, b.Path) })
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
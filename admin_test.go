@@ -0,0 +1,79 @@
+package xtemplate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAdminHandler(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`hello`)},
+		"style.css":  {Data: []byte(`body{}`)},
+	}
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fsys
+	server, err := config.Server(WithMetrics(NewMetrics()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	admin := server.AdminHandler()
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	status := func() adminStatus {
+		t.Helper()
+		w := do("GET", "/")
+		var s adminStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+			t.Fatalf("failed to decode status %q: %v", w.Body, err)
+		}
+		return s
+	}
+
+	s := status()
+	if s.Instance == nil || *s.Instance != server.Instance().Id() {
+		t.Errorf("expected status of the current instance, got %v", s.Instance)
+	}
+	if len(s.Routes) == 0 || len(s.Files) != 1 || s.Files[0].Path != "/style.css" {
+		t.Errorf("expected routes and the static file in the status, got %+v %+v", s.Routes, s.Files)
+	}
+
+	prev := server.Instance().Id()
+	if w := do("POST", "/reload"); w.Code != 200 || server.Instance().Id() == prev {
+		t.Errorf("expected reload to create a new instance, got %d %q", w.Code, w.Body)
+	}
+
+	fsys["bad.html"] = &fstest.MapFile{Data: []byte(`{{`)}
+	if w := do("POST", "/reload"); w.Code != 500 || !strings.Contains(w.Body.String(), "error") {
+		t.Errorf("expected a failed reload to respond with its error, got %d %q", w.Code, w.Body)
+	}
+	if s := status(); !s.ReloadFailed || s.ReloadError == nil {
+		t.Errorf("expected the status to report the failed reload, got %+v", s)
+	}
+	delete(fsys, "bad.html")
+
+	if w := do("GET", "/metrics"); w.Code != 200 || !strings.Contains(w.Body.String(), "xtemplate_") {
+		t.Errorf("expected metrics, got %d %q", w.Code, w.Body)
+	}
+
+	if w := do("POST", "/drain"); w.Code != 200 {
+		t.Errorf("expected drain to succeed, got %d %q", w.Code, w.Body)
+	}
+	if s := status(); s.Instance != nil {
+		t.Errorf("expected no instance after draining, got %d", *s.Instance)
+	}
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected requests to be refused after draining, got %d", w.Code)
+	}
+}
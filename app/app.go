@@ -2,9 +2,14 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/infogulch/xtemplate"
@@ -17,10 +22,20 @@ type Args struct {
 	xtemplate.Config
 	Watch          []string `json:"watch_dirs" arg:",separate"`
 	WatchTemplates bool     `json:"watch_templates"`
-	Listen         string   `json:"listen" arg:"-l"`
+	Listen         string   `json:"listen" arg:"-l" help:"listen address, prefix with unix: to listen on a unix socket. ignored if listeners are passed with LISTEN_FDS"`
+	TLSCert        string   `json:"tls_cert" arg:"--tls-cert" help:"serve tls with this certificate file, reloaded when it changes"`
+	TLSKey         string   `json:"tls_key" arg:"--tls-key" help:"serve tls with this key file, reloaded when it changes"`
+	H2C            bool     `json:"h2c" arg:"--h2c" help:"accept unencrypted http/2 connections (h2c), e.g. behind a proxy"`
+	AdminListen    string   `json:"admin_listen" arg:"--admin-listen" help:"serve the admin api and metrics on this address, disabled if empty. do not expose publicly"`
+	TraceOTLP      string   `json:"trace_otlp" arg:"--trace-otlp" help:"export request traces to this OTLP/HTTP traces endpoint, e.g. http://localhost:4318/v1/traces"`
+	TraceFile      string   `json:"trace_file" arg:"--trace-file" help:"write request traces as json lines to this file, or - for stdout"`
 	LogLevel       int      `json:"log_level" default:"-2"`
 	Configs        []string `json:"-" arg:"-c,--config,separate"`
 	ConfigFiles    []string `json:"-" arg:"-f,--config-file,separate"`
+
+	ExportCmd *ExportArgs `json:"-" arg:"subcommand:export" help:"render the site to static files instead of serving it"`
+	CheckCmd  *CheckArgs  `json:"-" arg:"subcommand:check" help:"report problems with the templates as json and exit non-zero if there are any"`
+	TestCmd   *TestArgs   `json:"-" arg:"subcommand:test" help:"run the TEST templates and exit non-zero if any fail"`
 }
 
 var version = "development"
@@ -38,59 +53,53 @@ var defaultArgs = Args{WatchTemplates: defaultWatchTemplates == "true", Listen:
 // Provide configs to override the defaults like:
 //
 //	app.Main(xtemplate.WithFooConfig())
+//
+// Sending SIGHUP to the process re-reads the cli args and config files and
+// reloads the server with the new config. Listen, tls, and watch settings are
+// only read at startup.
 func Main(overrides ...xtemplate.Option) {
-	var config Args = defaultArgs
-	var log *slog.Logger
-
-	{
-		arg.MustParse(&config)
-		config.Defaults()
-
-		level := config.LogLevel
-		log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.Level(level)}))
-
-		var jsonConfig Args = defaultArgs
-		var decoded bool
-		for _, name := range config.ConfigFiles {
-			func() {
-				file, err := os.OpenFile(name, os.O_RDONLY, 0)
-				if err != nil {
-					log.Error("failed to open config file '%s': %w", name, err)
-					os.Exit(1)
-				}
-				defer file.Close()
-				err = json.NewDecoder(file).Decode(&jsonConfig)
-				if err != nil {
-					log.Error("failed to decode args from json file", slog.String("filename", name), slog.Any("error", err))
-					os.Exit(1)
-				}
-				decoded = true
-				log.Debug("incorporated json file", slog.String("filename", name), slog.Any("config", &jsonConfig))
-			}() // use func to close file on every iteration
-		}
+	config, log, err := loadArgs(func(a *Args) error { arg.MustParse(a); return nil })
+	if err != nil {
+		log.Error("failed to load configuration", slog.Any("error", err))
+		os.Exit(1)
+	}
 
-		for _, conf := range config.Configs {
-			err := json.NewDecoder(bytes.NewBuffer([]byte(conf))).Decode(&jsonConfig)
-			if err != nil {
-				log.Error("failed to decode arg from json flag", slog.Any("error", err))
-				os.Exit(1)
-			}
-			decoded = true
-			log.Debug("incorporated json value", slog.String("json_string", conf), slog.Any("config", &jsonConfig))
+	if config.ExportCmd != nil {
+		if err := export(&config, log, overrides); err != nil {
+			log.Error("failed to export site", slog.Any("error", err))
+			os.Exit(1)
 		}
+		return
+	}
 
-		if decoded {
-			arg.MustParse(&jsonConfig)
-			config = jsonConfig
+	if config.CheckCmd != nil {
+		if check(&config, log, overrides) > 0 {
+			os.Exit(1)
 		}
+		return
+	}
 
-		if config.LogLevel != level {
-			log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.Level(config.LogLevel)}))
+	if config.TestCmd != nil {
+		if !runTests(&config, log, overrides) {
+			os.Exit(1)
 		}
+		return
+	}
 
-		config.Logger = log
+	if config.AdminListen != "" && config.Metrics == nil {
+		config.Metrics = xtemplate.NewMetrics()
+	}
 
-		log.Debug("loaded configuration", slog.Any("config", &config))
+	if config.Tracer == nil {
+		exporter, err := traceExporter(&config)
+		if err != nil {
+			log.Error("failed to configure tracing", slog.Any("error", err))
+			os.Exit(1)
+		}
+		if exporter != nil {
+			config.Tracer = xtemplate.NewTracer(exporter)
+			defer config.Tracer.Shutdown(context.Background())
+		}
 	}
 
 	server, err := config.Server(overrides...)
@@ -113,5 +122,123 @@ func Main(overrides ...xtemplate.Option) {
 		}
 	}
 
-	log.Info("server stopped", slog.Any("exit", server.Serve(config.Listen)))
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadArgs(server, log, overrides)
+		}
+	}()
+
+	log.Info("server stopped", slog.Any("exit", serve(server, &config, log)))
+}
+
+// traceExporter creates the span exporter configured by args, or nil if
+// tracing is not configured.
+func traceExporter(args *Args) (xtemplate.SpanExporter, error) {
+	switch {
+	case args.TraceOTLP != "" && args.TraceFile != "":
+		return nil, fmt.Errorf("only one of --trace-otlp and --trace-file can be used")
+	case args.TraceOTLP != "":
+		return xtemplate.NewOTLPExporter(args.TraceOTLP, "xtemplate", nil), nil
+	case args.TraceFile == "-":
+		return xtemplate.NewWriterExporter(uncloseable{os.Stdout}), nil
+	case args.TraceFile != "":
+		file, err := os.OpenFile(args.TraceFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		return xtemplate.NewWriterExporter(file), nil
+	}
+	return nil, nil
+}
+
+// uncloseable hides the Close method of a writer so the writer exporter
+// doesn't close stdout on shutdown.
+type uncloseable struct{ io.Writer }
+
+// reloadArgs re-reads cli args and config files and reloads server with the
+// resulting config. Errors are logged and leave the current instance in place.
+func reloadArgs(server *xtemplate.Server, log *slog.Logger, overrides []xtemplate.Option) {
+	log = log.WithGroup("sighup")
+	config, _, err := loadArgs(func(a *Args) error {
+		p, err := arg.NewParser(arg.Config{}, a)
+		if err != nil {
+			return err
+		}
+		return p.Parse(os.Args[1:])
+	})
+	if err != nil {
+		log.Error("failed to reload configuration", slog.Any("error", err))
+		return
+	}
+	if err := server.ReloadConfig(config.Config, overrides...); err != nil {
+		log.Error("failed to reload xtemplate with new configuration", slog.Any("error", err))
+		return
+	}
+	log.Info("reloaded configuration")
+}
+
+// loadArgs parses cli args with parse, then incorporates config from json
+// files and json values named in the args, then parses the cli args again so
+// they take precedence. It returns the logger configured by the args, which is
+// valid even if an error is returned.
+func loadArgs(parse func(*Args) error) (Args, *slog.Logger, error) {
+	var config Args = defaultArgs
+	var log *slog.Logger = slog.Default()
+
+	if err := parse(&config); err != nil {
+		return config, log, err
+	}
+	config.Defaults()
+
+	level := config.LogLevel
+	log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.Level(level)}))
+
+	var jsonConfig Args = defaultArgs
+	var decoded bool
+	for _, name := range config.ConfigFiles {
+		err := func() error {
+			file, err := os.OpenFile(name, os.O_RDONLY, 0)
+			if err != nil {
+				return fmt.Errorf("failed to open config file '%s': %w", name, err)
+			}
+			defer file.Close()
+			err = json.NewDecoder(file).Decode(&jsonConfig)
+			if err != nil {
+				return fmt.Errorf("failed to decode args from json file '%s': %w", name, err)
+			}
+			return nil
+		}() // use func to close file on every iteration
+		if err != nil {
+			return config, log, err
+		}
+		decoded = true
+		log.Debug("incorporated json file", slog.String("filename", name), slog.Any("config", &jsonConfig))
+	}
+
+	for _, conf := range config.Configs {
+		err := json.NewDecoder(bytes.NewBuffer([]byte(conf))).Decode(&jsonConfig)
+		if err != nil {
+			return config, log, fmt.Errorf("failed to decode arg from json flag: %w", err)
+		}
+		decoded = true
+		log.Debug("incorporated json value", slog.String("json_string", conf), slog.Any("config", &jsonConfig))
+	}
+
+	if decoded {
+		if err := parse(&jsonConfig); err != nil {
+			return config, log, err
+		}
+		config = jsonConfig
+	}
+
+	if config.LogLevel != level {
+		log = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.Level(config.LogLevel)}))
+	}
+
+	config.Logger = log
+
+	log.Debug("loaded configuration", slog.Any("config", &config))
+	return config, log, nil
 }
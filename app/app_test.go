@@ -0,0 +1,56 @@
+package app
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/infogulch/xtemplate"
+)
+
+func TestReloadArgs(t *testing.T) {
+	dir := t.TempDir()
+	templates := filepath.Join(dir, "templates")
+	if err := os.Mkdir(templates, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(templates, "index.html"), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configFile, []byte(`{"templates_dir": "`+filepath.ToSlash(templates)+`", "minify": false}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := xtemplate.New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{"index.html": {Data: []byte("old")}}
+	server, err := config.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	get := func() string {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Body.String()
+	}
+
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	// a config that fails to load leaves the current instance in place
+	os.Args = []string{"xtemplate", "--config-file", filepath.Join(dir, "missing.json")}
+	reloadArgs(server, config.Logger, nil)
+	if got := get(); got != "old" {
+		t.Errorf("expected a failed reload to keep the current instance, got %q", got)
+	}
+
+	os.Args = []string{"xtemplate", "--config-file", configFile}
+	reloadArgs(server, config.Logger, nil)
+	if got := get(); got != "new" {
+		t.Errorf("expected the server to be reloaded with the config file, got %q", got)
+	}
+}
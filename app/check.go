@@ -0,0 +1,39 @@
+package app
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"github.com/infogulch/xtemplate"
+)
+
+// CheckArgs are the arguments of the check subcommand, which reports problems
+// with the templates as a json array of [xtemplate.Problem] on stdout.
+type CheckArgs struct{}
+
+// check loads the templates configured by config and writes the problems it
+// finds to stdout. It returns the number of problems with severity `error`;
+// warnings are reported but don't fail the check.
+func check(config *Args, log *slog.Logger, overrides []xtemplate.Option) int {
+	// keep stdout clean for the json output
+	config.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.Level(config.LogLevel)}))
+	log = config.Logger
+
+	problems := config.Check(overrides...)
+	if problems == nil {
+		problems = []xtemplate.Problem{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(problems); err != nil {
+		log.Error("failed to write problems", slog.Any("error", err))
+	}
+	errors := 0
+	for _, p := range problems {
+		if p.Severity == "error" {
+			errors += 1
+		}
+	}
+	return errors
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/infogulch/xtemplate"
+)
+
+// ExportArgs are the arguments of the export subcommand, which renders every
+// page of the site to static files.
+type ExportArgs struct {
+	Out      string   `arg:"-o,--out,required" help:"directory to write the exported site to"`
+	URLs     []string `arg:"--url,separate" help:"additional url path to export, e.g. to render a route with wildcards. can be used multiple times"`
+	URLsFrom []string `arg:"--urls-from,separate" help:"url path of a route that responds with additional url paths to export, one per line. can be used multiple times"`
+}
+
+// precompressedExtensions are the extensions of precompressed variants of
+// static files that xtemplate serves.
+var precompressedExtensions = []string{".gz", ".br", ".zst"}
+
+// export loads an instance from config and writes every GET route without
+// wildcards except SSE routes, the urls listed in args, and all static files with their
+// precompressed variants to args.Out.
+func export(config *Args, log *slog.Logger, overrides []xtemplate.Option) error {
+	args := config.ExportCmd
+	if config.TemplatesFS == nil {
+		config.TemplatesFS = os.DirFS(config.TemplatesDir)
+	}
+	instance, stats, routes, err := config.Instance(append(slices.Clone(overrides), xtemplate.WithoutBackground())...)
+	if err != nil {
+		return fmt.Errorf("failed to load xtemplate: %w", err)
+	}
+	defer instance.Shutdown(context.Background())
+	log.Info("exporting site", slog.String("out", args.Out), slog.Any("stats", stats))
+
+	if err := os.MkdirAll(args.Out, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	var pages []string
+	var skipped int
+	for _, route := range routes {
+		method, hostpath, _ := strings.Cut(route.Pattern, " ")
+		hostpath = strings.TrimLeft(hostpath, " \t")
+		if method != "GET" || !strings.HasPrefix(hostpath, "/") {
+			continue
+		}
+		if route.Template == "" {
+			if err := exportStaticFile(config.TemplatesFS, args.Out, route.File, hostpath); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(route.Template, "SSE ") {
+			// event streams stay open and can't be saved to a file
+			log.Debug("skipping SSE route", slog.String("pattern", route.Pattern))
+			continue
+		}
+		urlpath := strings.TrimSuffix(hostpath, "{$}")
+		if strings.Contains(urlpath, "{") {
+			log.Debug("skipping route with wildcards", slog.String("pattern", route.Pattern))
+			skipped += 1
+			continue
+		}
+		pages = append(pages, urlpath)
+	}
+	if skipped > 0 {
+		log.Info("skipped routes with wildcards, list their urls with --url or --urls-from to export them", slog.Int("count", skipped))
+	}
+
+	pages = append(pages, args.URLs...)
+	for _, from := range args.URLsFrom {
+		res := render(instance, from)
+		if res.Code != http.StatusOK {
+			return fmt.Errorf("failed to get urls from '%s': status %d", from, res.Code)
+		}
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				pages = append(pages, line)
+			}
+		}
+	}
+
+	slices.Sort(pages)
+	pages = slices.Compact(pages)
+	var failed int
+	for _, page := range pages {
+		if err := exportPage(instance, args.Out, page); err != nil {
+			log.Error("failed to export page", slog.String("url", page), slog.Any("error", err))
+			failed += 1
+			continue
+		}
+		log.Debug("exported page", slog.String("url", page))
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to export %d of %d pages", failed, len(pages))
+	}
+	log.Info("exported site", slog.Int("pages", len(pages)), slog.Int("static_files", stats.StaticFiles))
+	return nil
+}
+
+// render serves a GET request for urlpath with instance.
+func render(instance *xtemplate.Instance, urlpath string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	instance.ServeHTTP(w, httptest.NewRequest("GET", urlpath, nil))
+	return w
+}
+
+// exportPage renders urlpath and writes the response to the file that a
+// static file host would serve for it, see pageFilePath.
+func exportPage(instance *xtemplate.Instance, out, urlpath string) error {
+	u, err := url.Parse(urlpath)
+	if err != nil || !strings.HasPrefix(u.Path, "/") {
+		return fmt.Errorf("invalid url path '%s'", urlpath)
+	}
+	res := render(instance, urlpath)
+	if res.Code != http.StatusOK {
+		return fmt.Errorf("response status %d", res.Code)
+	}
+	mediatype, _, _ := mime.ParseMediaType(res.Header().Get("Content-Type"))
+	if mediatype == "" {
+		mediatype = "text/html"
+	}
+	return writeFile(filepath.Join(out, filepath.FromSlash(pageFilePath(u.Path, mediatype))), res.Body)
+}
+
+// pageFilePath returns the path of the file to write a page served at urlpath
+// to. Directories are written to their index.html, and html pages without an
+// extension get an .html extension like most static hosts expect for clean
+// urls.
+func pageFilePath(urlpath, mediatype string) string {
+	if strings.HasSuffix(urlpath, "/") {
+		return path.Join(urlpath, "index.html")
+	}
+	if mediatype == "text/html" && path.Ext(urlpath) == "" {
+		return urlpath + ".html"
+	}
+	return urlpath
+}
+
+// exportStaticFile copies the static file at fspath in fsys and its
+// precompressed variants to the path it's served at in out.
+func exportStaticFile(fsys fs.FS, out, fspath, urlpath string) error {
+	for _, ext := range slices.Concat([]string{""}, precompressedExtensions) {
+		src := strings.TrimPrefix(fspath, "/") + ext
+		file, err := fsys.Open(src)
+		if ext != "" && err != nil {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open static file '%s': %w", src, err)
+		}
+		err = writeFile(filepath.Join(out, filepath.FromSlash(urlpath+ext)), file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(name string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for '%s': %w", name, err)
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create file '%s': %w", name, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, r); err != nil {
+		return fmt.Errorf("failed to write file '%s': %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/infogulch/xtemplate"
+)
+
+func TestPageFilePath(t *testing.T) {
+	tests := []struct {
+		urlpath, mediatype string
+		want               string
+	}{
+		{"/", "text/html", "/index.html"},
+		{"/blog/", "text/html", "/blog/index.html"},
+		{"/about", "text/html", "/about.html"},
+		{"/about.html", "text/html", "/about.html"},
+		{"/sitemap.xml", "application/xml", "/sitemap.xml"},
+		{"/data", "application/json", "/data"},
+		{"/feed/", "application/atom+xml", "/feed/index.html"},
+	}
+	for _, test := range tests {
+		if got := pageFilePath(test.urlpath, test.mediatype); got != test.want {
+			t.Errorf("pageFilePath(%q, %q): expected %q, got %q", test.urlpath, test.mediatype, test.want, got)
+		}
+	}
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(s))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExportStaticFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/style.css":    {Data: []byte("body{}")},
+		"css/style.css.gz": {Data: []byte("gz")},
+		"css/style.css.br": {Data: []byte("br")},
+		"plain.txt":        {Data: []byte("plain")},
+	}
+	out := t.TempDir()
+	if err := exportStaticFile(fsys, out, "/css/style.css", "/css/style.css"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportStaticFile(fsys, out, "/plain.txt", "/plain.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportStaticFile(fsys, out, "/missing.txt", "/missing.txt"); err == nil {
+		t.Error("expected an error for a missing static file")
+	}
+	want := map[string]string{
+		"css/style.css":    "body{}",
+		"css/style.css.gz": "gz",
+		"css/style.css.br": "br",
+		"plain.txt":        "plain",
+	}
+	checkFiles(t, out, want)
+}
+
+func TestExport(t *testing.T) {
+	config := Args{Config: *xtemplate.New()}
+	config.Minify = false
+	config.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	config.TemplatesFS = fstest.MapFS{
+		"index.html":       {Data: []byte(`home`)},
+		"about.html":       {Data: []byte(`about`)},
+		"blog/index.html":  {Data: []byte(`blog`)},
+		".posts.html":      {Data: []byte(`{{define "GET /posts/{id}"}}post {{.Req.PathValue "id"}}{{end}}{{define "POST /posts"}}{{end}}`)},
+		".events.html":     {Data: []byte(`{{define "SSE /events"}}{{end}}`)},
+		"sitemap.xml.tmpl": {Data: []byte(`<urlset/>`)},
+		"urls.txt.tmpl":    {Data: []byte("# posts\n/posts/2\n\n/posts/3\n")},
+		"style.css":        {Data: []byte("body{}")},
+		"style.css.gz":     {Data: gzipped(t, "body{}")},
+	}
+	out := t.TempDir()
+	config.ExportCmd = &ExportArgs{Out: out, URLs: []string{"/posts/1"}, URLsFrom: []string{"/urls.txt"}}
+	if err := export(&config, config.Logger, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, out, map[string]string{
+		"index.html":   "home",
+		"about.html":   "about",
+		"blog.html":    "blog",
+		"posts/1.html": "post 1",
+		"posts/2.html": "post 2",
+		"posts/3.html": "post 3",
+		"sitemap.xml":  "<urlset/>",
+		"urls.txt":     "# posts\n/posts/2\n\n/posts/3\n",
+		"style.css":    "body{}",
+		"style.css.gz": string(gzipped(t, "body{}")),
+		"events.html":  "",
+		"posts.html":   "",
+	})
+
+	config.ExportCmd = &ExportArgs{Out: t.TempDir(), URLs: []string{"no-slash"}}
+	if err := export(&config, config.Logger, nil); err == nil || !strings.Contains(err.Error(), "failed to export 1 of") {
+		t.Errorf("expected a failed page to fail the export, got %v", err)
+	}
+}
+
+// checkFiles compares the files in dir with want, where files with empty
+// content must not exist.
+func checkFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	var got []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if content == "" {
+			if err == nil {
+				t.Errorf("expected %s to not be exported", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected %s to be exported: %v", name, err)
+		} else if string(data) != content {
+			t.Errorf("expected %s to contain %q, got %q", name, content, data)
+		}
+	}
+	for _, name := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("unexpected exported file %s", name)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infogulch/watch"
+	"github.com/infogulch/xtemplate"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// serve configures an http.Server from args and serves server with it until
+// the server's context is cancelled.
+func serve(server *xtemplate.Server, args *Args, log *slog.Logger) error {
+	listeners, err := listen(args.Listen, log)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{}
+
+	if args.TLSCert != "" || args.TLSKey != "" {
+		if args.H2C {
+			return fmt.Errorf("h2c cannot be used with tls")
+		}
+		certs, err := newCertReloader(args.TLSCert, args.TLSKey, log.WithGroup("tls"))
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	}
+
+	if args.H2C {
+		srv.Handler = h2c.NewHandler(server.Handler(), &http2.Server{})
+	}
+
+	if args.AdminListen != "" {
+		ln, err := listenAddr(args.AdminListen)
+		if err != nil {
+			return fmt.Errorf("failed to open admin listener: %w", err)
+		}
+		adminSrv := &http.Server{Handler: server.AdminHandler(), ErrorLog: slog.NewLogLogger(log.Handler(), slog.LevelWarn)}
+		defer adminSrv.Close()
+		go func() {
+			log.Info("starting admin server", slog.String("listen_addr", ln.Addr().String()))
+			if err := adminSrv.Serve(ln); err != http.ErrServerClosed {
+				log.Error("admin server stopped", slog.Any("error", err))
+			}
+		}()
+	}
+
+	return server.ServeListeners(srv, listeners...)
+}
+
+// listen opens the listeners to serve from. If the process was started with
+// systemd-style socket activation then the inherited listeners are used,
+// otherwise addr is opened. Addresses prefixed with `unix:` listen on a unix
+// socket at the given path, all others listen on tcp.
+func listen(addr string, log *slog.Logger) ([]net.Listener, error) {
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(inherited) > 0 {
+		log.Info("using inherited listeners", slog.Int("count", len(inherited)))
+		return inherited, nil
+	}
+
+	ln, err := listenAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{ln}, nil
+}
+
+// listenAddr opens a listener on a unix socket if addr is prefixed with
+// `unix:`, otherwise on tcp.
+func listenAddr(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// remove a stale socket left by a previous process that didn't exit cleanly
+		if stat, err := os.Stat(path); err == nil && stat.Mode().Type() == fs.ModeSocket {
+			os.Remove(path)
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on unix socket '%s': %w", path, err)
+		}
+		return ln, nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on '%s': %w", addr, err)
+	}
+	return ln, nil
+}
+
+// listenFdsStart is the first file descriptor passed by systemd socket
+// activation, see sd_listen_fds(3).
+const listenFdsStart = 3
+
+// inheritedListeners returns the listeners passed to this process by the
+// LISTEN_PID, LISTEN_FDS, and LISTEN_FDNAMES environment variables, as
+// described by sd_listen_fds(3). The variables are unset so they are not
+// inherited by child processes.
+func inheritedListeners() ([]net.Listener, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	if fds == "" {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS '%s': %w", fds, err)
+	}
+
+	fdnames := strings.Split(names, ":")
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFdsStart+i)
+		if i < len(fdnames) && fdnames[i] != "" {
+			name = fdnames[i]
+		}
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use inherited file descriptor %d (%s) as a listener: %w", listenFdsStart+i, name, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// certReloader holds a tls certificate loaded from disk and reloads it when
+// the cert or key file changes.
+type certReloader struct {
+	certFile, keyFile string
+	log               *slog.Logger
+
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string, log *slog.Logger) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both a tls cert and key file are required")
+	}
+	c := &certReloader{certFile: certFile, keyFile: keyFile, log: log}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	// watch the directories instead of the files, since certificate renewal
+	// tools often replace files by renaming over them
+	dirs := []string{filepath.Dir(certFile)}
+	if dir := filepath.Dir(keyFile); dir != dirs[0] {
+		dirs = append(dirs, dir)
+	}
+	_, err := watch.Watch(dirs, 200*time.Millisecond, log.WithGroup("fswatch"), func() bool {
+		if err := c.load(); err != nil {
+			log.Warn("failed to reload tls certificate, continuing to use the previous certificate", slog.Any("error", err))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch tls certificate files: %w", err)
+	}
+	return c, nil
+}
+
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load tls certificate: %w", err)
+	}
+	c.mutex.Lock()
+	c.cert = &cert
+	c.mutex.Unlock()
+	c.log.Info("loaded tls certificate", slog.String("cert_file", c.certFile), slog.String("key_file", c.keyFile))
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, nil
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListenAddr(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "xtemplate.sock")
+
+	// leave a stale socket file behind, like a process that didn't exit cleanly
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	tests := []struct {
+		addr    string
+		network string
+		error   string
+	}{
+		{"unix:" + sock, "unix", ""},
+		{"127.0.0.1:0", "tcp", ""},
+		{"unix:" + filepath.Join(sock, "missing", "dir.sock"), "", "failed to listen on unix socket"},
+		{"127.0.0.1:badport", "", "failed to listen on '127.0.0.1:badport'"},
+	}
+	for _, test := range tests {
+		ln, err := listenAddr(test.addr)
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("listenAddr(%q): expected error containing %q, got %v", test.addr, test.error, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("listenAddr(%q): %v", test.addr, err)
+			continue
+		}
+		if got := ln.Addr().Network(); got != test.network {
+			t.Errorf("listenAddr(%q): expected network %q, got %q", test.addr, test.network, got)
+		}
+		ln.Close()
+	}
+}
+
+func TestInheritedListeners(t *testing.T) {
+	tests := []struct {
+		desc      string
+		pid, fds  string
+		listeners int
+		error     string
+	}{
+		{"not socket activated", "", "", 0, ""},
+		{"for another process", "1", "1", 0, ""},
+		{"no listeners", strconv.Itoa(os.Getpid()), "0", 0, ""},
+		{"invalid count", strconv.Itoa(os.Getpid()), "x", 0, "invalid LISTEN_FDS 'x'"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", test.pid)
+			t.Setenv("LISTEN_FDS", test.fds)
+			t.Setenv("LISTEN_FDNAMES", "")
+			listeners, err := inheritedListeners()
+			if test.error != "" {
+				if err == nil || !strings.Contains(err.Error(), test.error) {
+					t.Fatalf("expected error containing %q, got %v", test.error, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if len(listeners) != test.listeners {
+				t.Errorf("expected %d listeners, got %d", test.listeners, len(listeners))
+			}
+			if test.fds != "" && os.Getenv("LISTEN_FDS") != "" {
+				t.Errorf("expected LISTEN_FDS to be unset so it isn't inherited by child processes")
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/infogulch/xtemplate"
+)
+
+// TestArgs are the arguments of the test subcommand, which runs the test
+// templates, see [xtemplate.DotTest].
+type TestArgs struct {
+	Run     string `arg:"--run" help:"only run tests whose names match this regular expression"`
+	Verbose bool   `arg:"-v,--verbose" help:"print the name and logs of every test, not only failures"`
+}
+
+// runTests runs the test templates configured by config and prints the
+// results to stdout in the style of `go test`. It returns false if any test
+// failed.
+func runTests(config *Args, log *slog.Logger, overrides []xtemplate.Option) bool {
+	args := config.TestCmd
+	// instance logs would drown out the test output
+	level := slog.Level(config.LogLevel)
+	if !args.Verbose {
+		level = max(level, slog.LevelWarn)
+	}
+	config.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	results, err := config.RunTests(args.Run, overrides...)
+	if err != nil {
+		log.Error("failed to load templates", slog.Any("error", err))
+		fmt.Println("FAIL")
+		return false
+	}
+	if len(results) == 0 {
+		fmt.Println("testing: warning: no tests to run")
+	}
+
+	passed := true
+	for _, result := range results {
+		if args.Verbose {
+			fmt.Printf("=== RUN   %s\n", result.Name)
+		}
+		if result.Passed() {
+			if args.Verbose {
+				fmt.Printf("--- PASS: %s (%.2fs)\n", result.Name, result.Duration.Seconds())
+				printTestOutput(os.Stdout, result)
+			}
+			continue
+		}
+		passed = false
+		fmt.Printf("--- FAIL: %s (%.2fs)\n", result.Name, result.Duration.Seconds())
+		printTestOutput(os.Stdout, result)
+	}
+	if passed {
+		fmt.Println("PASS")
+	} else {
+		fmt.Println("FAIL")
+	}
+	return passed
+}
+
+func printTestOutput(w io.Writer, result xtemplate.TestResult) {
+	var lines []string
+	lines = append(lines, result.Logs...)
+	lines = append(lines, result.Failures...)
+	if result.Err != nil {
+		lines = append(lines, result.Err.Error())
+	}
+	for _, line := range lines {
+		if result.File != "" {
+			line = result.File + ": " + line
+		}
+		fmt.Fprintf(w, "    %s\n", strings.ReplaceAll(line, "\n", "\n        "))
+	}
+}
@@ -3,17 +3,21 @@ package xtemplate
 // These types and methods are used while creating an instance
 
 import (
+	"cmp"
 	"compress/gzip"
 	"crypto/sha512"
 	"encoding/base64"
 	"fmt"
+	"html/template"
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"net/http"
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 	"text/template/parse"
@@ -22,27 +26,47 @@ import (
 	"github.com/andybalholm/brotli"
 	"github.com/klauspost/compress/zstd"
 	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/minify/v2/json"
+	"github.com/tdewolff/minify/v2/svg"
+	"github.com/tdewolff/minify/v2/xml"
 )
 
 type builder struct {
 	*Instance
 	*InstanceStats
-	m      *minify.M
-	routes []InstanceRoute
+	m           *minify.M
+	routes      []InstanceRoute
+	layouts     map[string]*layoutFile
+	middlewares map[string]*template.Template
+	inits       []initTemplate
 }
 
 type InstanceStats struct {
-	Routes                        int
-	TemplateFiles                 int
-	TemplateDefinitions           int
-	TemplateInitializers          int
-	StaticFiles                   int
-	StaticFilesAlternateEncodings int
+	Routes                        int `json:"routes"`
+	TemplateFiles                 int `json:"template_files"`
+	TemplateDefinitions           int `json:"template_definitions"`
+	TemplateInitializers          int `json:"template_initializers"`
+	ErrorTemplates                int `json:"error_templates"`
+	Middlewares                   int `json:"middlewares"`
+	LayoutPages                   int `json:"layout_pages"`
+	ScheduledTemplates            int `json:"scheduled_templates"`
+	JobTemplates                  int `json:"job_templates"`
+	StaticFiles                   int `json:"static_files"`
+	StaticFilesAlternateEncodings int `json:"static_files_alternate_encodings"`
 }
 
 type InstanceRoute struct {
 	Pattern string
 	Handler http.Handler
+	// Template is the name of the template that handles the route, or empty
+	// for static files.
+	Template string
+	// File is the path of the template file or static file that added the
+	// route, relative to the templates root and starting with '/'.
+	File string
 }
 
 type fileInfo struct {
@@ -137,13 +161,10 @@ func (b *builder) addStaticFileHandler(path_ string) error {
 
 		pattern := "GET " + identityPath
 		handler := staticFileHandler(b.config.TemplatesFS, file)
-		if err = catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.HandleFunc(pattern, handler) }); err != nil {
-			return err
-		}
 		b.StaticFiles += 1
 		b.Routes += 1
 		b.files[identityPath] = file
-		b.routes = append(b.routes, InstanceRoute{pattern, handler})
+		b.routes = append(b.routes, InstanceRoute{Pattern: pattern, Handler: handler, File: path.Clean("/" + path_)})
 
 		b.config.Logger.Debug("added static file handler", slog.String("path", identityPath), slog.String("filepath", path_), slog.String("contenttype", file.contentType), slog.Int64("size", size), slog.Time("modtime", stat.ModTime()), slog.String("hash", sri))
 	} else {
@@ -158,6 +179,183 @@ func (b *builder) addStaticFileHandler(path_ string) error {
 	return nil
 }
 
+// duplicateDefinition records that the template name defined in the file
+// path_ overrides the definition from prevPath.
+func (b *builder) duplicateDefinition(name, path_, prevPath string, tree *parse.Tree) {
+	b.config.Logger.Warn("overriding named template with definition from another file", slog.String("name", name), slog.String("template_path", path_), slog.String("previous_path", prevPath))
+	p := Problem{Severity: "warning", Code: "duplicate-template", Message: fmt.Sprintf("template '%s' is also defined in '%s'", name, prevPath), Template: name}
+	p.File, p.Line, p.Column = nodeLocation(tree, tree.Root)
+	b.problems = append(b.problems, p)
+}
+
+// newMinifier creates the minifier used to minify template files at load
+// time.
+func newMinifier(config *Config) *minify.M {
+	m := minify.New()
+	m.Add("text/css", &css.Minifier{})
+	m.Add("image/svg+xml", &svg.Minifier{})
+	m.Add("text/html", &html.Minifier{
+		TemplateDelims: [...]string{config.LDelim, config.RDelim},
+	})
+	m.AddRegexp(regexp.MustCompile("^(application|text)/(x-)?(java|ecma)script$"), &js.Minifier{})
+	return m
+}
+
+// newOutputMinifier creates the minifier used to minify the output of text
+// templates, which can't be minified at load time like html templates because
+// template actions aren't valid syntax in their content types.
+func newOutputMinifier() *minify.M {
+	m := minify.New()
+	m.Add("text/css", &css.Minifier{})
+	m.Add("image/svg+xml", &svg.Minifier{})
+	m.Add("text/html", &html.Minifier{})
+	m.AddRegexp(regexp.MustCompile("^(application|text)/(x-)?(java|ecma)script$"), &js.Minifier{})
+	m.AddRegexp(regexp.MustCompile(`^(application|text)/(.+\+)?json$`), &json.Minifier{})
+	m.AddRegexp(regexp.MustCompile(`^(application|text)/(.+\+)?xml$`), &xml.Minifier{})
+	return m
+}
+
+// readTemplate reads a template file and minifies it if m is not nil.
+func readTemplate(fsys fs.FS, m *minify.M, path_ string) (string, error) {
+	content, err := fs.ReadFile(fsys, path_)
+	if err != nil {
+		return "", fmt.Errorf("could not read template file '%s': %v", path_, err)
+	}
+	if m != nil {
+		content, err = m.Bytes("text/html", content)
+		if err != nil {
+			return "", fmt.Errorf("could not minify template file '%s': %v", path_, err)
+		}
+	}
+	return string(content), nil
+}
+
+// registerRoutes wraps the handler of each route with the middleware templates
+// whose path prefix matches the route, outermost prefix first, and registers
+// it with the router.
+func (b *builder) registerRoutes() error {
+	prefixes := slices.Collect(maps.Keys(b.middlewares))
+	slices.SortFunc(prefixes, func(a, b string) int {
+		return cmp.Or(cmp.Compare(strings.Count(a, "/"), strings.Count(b, "/")), strings.Compare(a, b))
+	})
+	for i, route := range b.routes {
+		handler := route.Handler
+		routePath := patternPath(route.Pattern)
+		for j := len(prefixes) - 1; j >= 0; j-- {
+			if matchPathPrefix(routePath, prefixes[j]) {
+				handler = middlewareHandler(b.Instance, b.middlewares[prefixes[j]], handler)
+			}
+		}
+		b.routes[i].Handler = handler
+		if err := catch(fmt.Sprintf("add handler to servemux '%s' from '%s'", route.Pattern, route.File), func() { b.router.Handle(route.Pattern, handler) }); err != nil {
+			return err
+		}
+	}
+	b.addOptionsRoutes()
+	return nil
+}
+
+// addOptionsRoutes registers a handler for OPTIONS requests to each host and
+// path that has routes but no explicit OPTIONS route. It responds with an
+// Allow header listing the methods registered for the path. Paths that only
+// differ by the names of their wildcards, like `/items/{id}` and
+// `/items/{item}`, are the same path. These are not wrapped by middleware so
+// that CORS preflight requests aren't rejected by authentication checks.
+func (b *builder) addOptionsRoutes() {
+	allowed := map[string][]string{}
+	hostpaths := map[string]string{}
+	var shapes []string
+	for _, route := range b.routes {
+		method, hostpath, ok := strings.Cut(route.Pattern, " ")
+		if !ok {
+			continue
+		}
+		hostpath = strings.TrimLeft(hostpath, " \t")
+		shape := patternShape(hostpath)
+		if _, ok := allowed[shape]; !ok {
+			shapes = append(shapes, shape)
+			hostpaths[shape] = hostpath
+		}
+		allowed[shape] = append(allowed[shape], method)
+	}
+	for _, shape := range shapes {
+		methods, hostpath := allowed[shape], hostpaths[shape]
+		if slices.Contains(methods, http.MethodOptions) {
+			continue
+		}
+		if slices.Contains(methods, http.MethodGet) {
+			methods = append(methods, http.MethodHead)
+		}
+		methods = append(methods, http.MethodOptions)
+		slices.Sort(methods)
+		allow := strings.Join(slices.Compact(methods), ", ")
+
+		pattern := http.MethodOptions + " " + hostpath
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+		})
+		if err := catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.Handle(pattern, handler) }); err != nil {
+			// patterns that don't conflict for their own methods may conflict
+			// as OPTIONS patterns; skip them instead of failing the load
+			b.config.Logger.Warn("skipped automatic OPTIONS route that conflicts with another route, define an OPTIONS route for it explicitly", slog.String("pattern", pattern), slog.Any("error", err))
+			continue
+		}
+		b.routes = append(b.routes, InstanceRoute{Pattern: pattern, Handler: handler})
+		b.Routes += 1
+	}
+}
+
+// patternPath returns the path part of a ServeMux pattern, without its method
+// and host.
+func patternPath(pattern string) string {
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i:], " \t")
+	}
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		return pattern[i:]
+	}
+	return pattern
+}
+
+// patternShape returns hostpath with the names of its wildcards removed, so
+// that patterns which match the same requests have the same shape.
+func patternShape(hostpath string) string {
+	segments := strings.Split(hostpath, "/")
+	for i, seg := range segments {
+		if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") || seg == "{$}" {
+			continue
+		}
+		if strings.HasSuffix(seg, "...}") {
+			segments[i] = "{...}"
+		} else {
+			segments[i] = "{}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// matchPathPrefix reports whether the path of a route is equal to or under
+// prefix, which has no trailing slash. A wildcard segment in prefix like
+// `{tenant}` matches any segment of the route.
+func matchPathPrefix(routePath, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	prefixSegments := strings.Split(prefix[1:], "/")
+	routeSegments := strings.Split(strings.TrimPrefix(routePath, "/"), "/")
+	if len(routeSegments) < len(prefixSegments) {
+		return false
+	}
+	for i, seg := range prefixSegments {
+		wildcard := strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") && seg != "{$}"
+		if seg != routeSegments[i] && !(wildcard && routeSegments[i] != "") {
+			return false
+		}
+	}
+	return true
+}
+
 func catch(description string, fn func()) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -168,32 +366,75 @@ func catch(description string, fn func()) (err error) {
 	return
 }
 
-var routeMatcher *regexp.Regexp = regexp.MustCompile("^(GET|POST|PUT|PATCH|DELETE|SSE) (.*)$")
+// routeMatcher matches template names that define a route with a
+// [http.ServeMux] pattern like `METHOD [HOST]/[PATH]`. Any uppercase method is
+// accepted, and `SSE` is a GET route handled by a flushing template handler.
+var routeMatcher *regexp.Regexp = regexp.MustCompile(`^([A-Z]+)[ \t]+([^ \t/]*/.*)$`)
 
-func (b *builder) addTemplateHandler(path_ string) error {
-	content, err := fs.ReadFile(b.config.TemplatesFS, path_)
-	if err != nil {
-		return fmt.Errorf("could not read template file '%s': %v", path_, err)
+// looksLikeRoute matches template names that were probably intended to define
+// a route, to warn about those that don't match routeMatcher.
+var looksLikeRoute *regexp.Regexp = regexp.MustCompile(`(?i)^((GET|HEAD|POST|PUT|PATCH|DELETE|CONNECT|OPTIONS|TRACE|QUERY|SSE)([ \t/]|$)|[a-z]+[ \t]+[^ \t]*/)`)
+
+// reservedTemplateKeywords are the first words of template names with a
+// special meaning that are not routes.
+var reservedTemplateKeywords = []string{"INIT", "ERROR", "MIDDLEWARE", "TEST", "CRON", "EVERY", "JOB"}
+
+// isSpecialTemplateName reports whether name defines a route or starts with a
+// reserved keyword.
+func isSpecialTemplateName(name string) bool {
+	first, _, _ := strings.Cut(name, " ")
+	_, _, isRoute := parseRouteName(name)
+	return isRoute || slices.Contains(reservedTemplateKeywords, first)
+}
+
+// parseRouteName returns the method and the rest of the pattern of a template
+// name that defines a route.
+func parseRouteName(name string) (method, hostpath string, ok bool) {
+	matches := routeMatcher.FindStringSubmatch(name)
+	if len(matches) != 3 || slices.Contains(reservedTemplateKeywords, matches[1]) {
+		return "", "", false
 	}
-	if b.m != nil {
-		content, err = b.m.Bytes("text/html", content)
+	return matches[1], matches[2], true
+}
+
+var errorMatcher *regexp.Regexp = regexp.MustCompile(`^ERROR (\d{3}|\*)$`)
+
+func (b *builder) addTemplateHandler(path_ string) error {
+	var newtemplates map[string]*parse.Tree
+	if cleaned := path.Clean("/" + path_); cleaned == b.layoutPath(path.Dir(cleaned)) {
+		// layouts may have been parsed already by a page in their directory
+		l, err := b.layout(path.Dir(cleaned))
 		if err != nil {
-			return fmt.Errorf("could not minify template file '%s': %v", path_, err)
+			return err
+		}
+		path_, newtemplates = l.path, l.trees
+	} else {
+		var err error
+		if path_, newtemplates, err = b.parseTemplateFile(path_, b.m); err != nil {
+			return err
 		}
 	}
-	path_ = path.Clean("/" + path_)
-	// parse each template file manually to have more control over its final
-	// names in the template namespace.
-	newtemplates, err := parse.Parse(path_, string(content), b.config.LDelim, b.config.RDelim, b.funcs, buliltinsSkeleton)
+	b.TemplateFiles += 1
+
+	overrides, err := b.layoutBlocks(path_, newtemplates)
 	if err != nil {
-		return fmt.Errorf("could not parse template file '%s': %v", path_, err)
+		return err
 	}
-	b.TemplateFiles += 1
 
 	// add parsed templates, register handlers
 	for name, tree := range newtemplates {
-		if b.templates.Lookup(name) != nil {
-			b.config.Logger.Debug("overriding named template '%s' with definition from file: %s", name, path_)
+		if name == noLayoutName {
+			continue
+		}
+		if overrides[name] {
+			// blocks that override a layout only apply to the pages they wrap,
+			// see pageTemplates
+			b.TemplateDefinitions += 1
+			continue
+		}
+		// error templates are scoped to their directory, see below
+		if prev := b.templates.Lookup(name); prev != nil && prev.Tree != nil && !errorMatcher.MatchString(name) {
+			b.duplicateDefinition(name, path_, prev.Tree.ParseName, tree)
 		}
 		tmpl, err := b.templates.AddParseTree(name, tree)
 		if err != nil {
@@ -209,6 +450,13 @@ func (b *builder) addTemplateHandler(path_ string) error {
 			if len(file) > 0 && file[0] == '.' {
 				continue
 			}
+			layoutTmpl, fragments, err := b.pageTemplates(path_, newtemplates)
+			if err != nil {
+				return err
+			}
+			if layoutTmpl != nil {
+				tmpl = layoutTmpl
+			}
 			// strip the extension from the handled path
 			routePath := strings.TrimSuffix(path_, b.config.TemplateExtension)
 			// files named 'index' handle requests to the directory
@@ -221,24 +469,83 @@ func (b *builder) addTemplateHandler(path_ string) error {
 			}
 			routePath = path.Clean(routePath)
 			pattern = "GET " + routePath
-			handler = bufferingTemplateHandler(b.Instance, tmpl)
-		} else if matches := routeMatcher.FindStringSubmatch(name); len(matches) == 3 {
-			method, path_ := matches[1], matches[2]
+			handler = bufferingTemplateHandler(b.Instance, tmpl, fragments)
+		} else if matches := errorMatcher.FindStringSubmatch(name); len(matches) == 2 {
+			// Error templates are scoped to the directory of the file that
+			// defines them, so each definition is added under its own name.
+			dir := path.Dir(path_)
+			scoped := name + " " + dir
+			tmpl, err := b.templates.AddParseTree(scoped, tree.Copy())
+			if err != nil {
+				return fmt.Errorf("could not add template '%s' from '%s': %v", scoped, path_, err)
+			}
+			key := errorTemplateKey{dir, matches[1]}
+			if _, ok := b.errorTemplates[key]; ok {
+				return fmt.Errorf("error template '%s' is defined more than once in directory '%s'", name, dir)
+			}
+			b.errorTemplates[key] = tmpl
+			b.ErrorTemplates += 1
+			b.config.Logger.Debug("added error template", slog.String("name", name), slog.String("dir", dir), slog.String("template_path", path_))
+			continue
+		} else if prefix, ok := strings.CutPrefix(name, "MIDDLEWARE "); ok {
+			if !strings.HasPrefix(prefix, "/") {
+				return fmt.Errorf("middleware template '%s' in '%s' must have a path prefix that starts with '/'", name, path_)
+			}
+			prefix = strings.TrimSuffix(path.Clean(prefix), "/")
+			if _, ok := b.middlewares[prefix]; ok {
+				return fmt.Errorf("middleware for path prefix '%s/' is defined more than once", prefix)
+			}
+			b.middlewares[prefix] = tmpl
+			b.Middlewares += 1
+			b.config.Logger.Debug("added middleware template", slog.String("name", name), slog.String("template_path", path_))
+			continue
+		} else if strings.HasPrefix(name, "INIT ") {
+			it, err := parseInitName(tmpl)
+			if err != nil {
+				return fmt.Errorf("invalid initializer template '%s' in '%s': %w", name, path_, err)
+			}
+			// a later definition of the same name overrides the previous one
+			b.inits = slices.DeleteFunc(b.inits, func(prev initTemplate) bool { return prev.tmpl.Name() == name })
+			b.inits = append(b.inits, it)
+			continue
+		} else if strings.HasPrefix(name, "TEST ") {
+			b.tests = append(b.tests, tmpl)
+			b.config.Logger.Debug("added test template", slog.String("name", name), slog.String("template_path", path_))
+			continue
+		} else if strings.HasPrefix(name, "CRON ") || strings.HasPrefix(name, "EVERY ") {
+			schedule, err := parseSchedule(name)
+			if err != nil {
+				return fmt.Errorf("invalid schedule in template '%s' in '%s': %w", name, path_, err)
+			}
+			b.schedules = append(b.schedules, scheduledTemplate{tmpl, schedule})
+			b.ScheduledTemplates += 1
+			b.config.Logger.Debug("added scheduled template", slog.String("name", name), slog.String("template_path", path_))
+			continue
+		} else if strings.HasPrefix(name, "JOB ") {
+			job, err := jobTemplateName(name)
+			if err != nil {
+				return fmt.Errorf("invalid job template '%s' in '%s': %w", name, path_, err)
+			}
+			b.jobTemplates[job] = tmpl
+			b.JobTemplates += 1
+			b.config.Logger.Debug("added job template", slog.String("name", name), slog.String("template_path", path_))
+			continue
+		} else if method, hostpath, ok := parseRouteName(name); ok {
 			if method == "SSE" {
-				pattern = "GET " + path_
+				pattern = "GET " + hostpath
 				handler = flushingTemplateHandler(b.Instance, tmpl)
 			} else {
-				pattern = method + " " + path_
-				handler = bufferingTemplateHandler(b.Instance, tmpl)
+				pattern = method + " " + hostpath
+				handler = bufferingTemplateHandler(b.Instance, tmpl, nil)
 			}
 		} else {
+			if looksLikeRoute.MatchString(name) && !isSpecialTemplateName(name) {
+				b.config.Logger.Warn("template name looks like a route but is not a valid route pattern, expected 'METHOD [HOST]/PATH'", slog.String("name", name), slog.String("template_path", path_))
+			}
 			continue
 		}
 
-		if err = catch(fmt.Sprintf("add handler to servemux '%s'", pattern), func() { b.router.HandleFunc(pattern, handler) }); err != nil {
-			return err
-		}
-		b.routes = append(b.routes, InstanceRoute{pattern, handler})
+		b.routes = append(b.routes, InstanceRoute{Pattern: pattern, Handler: handler, Template: name, File: path_})
 		b.Routes += 1
 		b.config.Logger.Debug("added template handler", "method", "GET", "pattern", pattern, "template_path", path_)
 	}
@@ -0,0 +1,162 @@
+package xtemplate
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPatternPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"/", "/"},
+		{"GET /a/b", "/a/b"},
+		{"GET\t /a", "/a"},
+		{"POST example.com/a/{id}", "/a/{id}"},
+		{"example.com/", "/"},
+	}
+	for _, test := range tests {
+		if got := patternPath(test.pattern); got != test.want {
+			t.Errorf("patternPath(%q): expected %q, got %q", test.pattern, test.want, got)
+		}
+	}
+}
+
+func TestMatchPathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{"/", "", true},
+		{"/anything/at/all", "", true},
+		{"/admin", "/admin", true},
+		{"/admin/", "/admin", true},
+		{"/admin/users/{id}", "/admin", true},
+		{"/administrator", "/admin", false},
+		{"/", "/admin", false},
+		{"/api/v1", "/api/v1/users", false},
+		{"/t/acme/dashboard", "/t/{tenant}", true},
+		{"/t/{name}/dashboard", "/t/{tenant}", true},
+		{"/t/", "/t/{tenant}", false},
+		{"/t", "/t/{tenant}", false},
+		{"/files/{path...}", "/files/{rest...}", true},
+		{"/a/{$}", "/a/{$}", true},
+		{"/a/b", "/a/{$}", false},
+	}
+	for _, test := range tests {
+		if got := matchPathPrefix(test.path, test.prefix); got != test.want {
+			t.Errorf("matchPathPrefix(%q, %q): expected %t, got %t", test.path, test.prefix, test.want, got)
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"mw.html": {Data: []byte(`
+{{- define "MIDDLEWARE /"}}{{.Resp.AddHeader "X-Root" "root"}}{{end}}
+{{- define "MIDDLEWARE /admin/"}}{{.Resp.AddHeader "X-Admin" "admin"}}{{if not (.Req.URL.Query.Has "ok")}}{{.Resp.ReturnStatus 403}}{{end}}{{end}}
+{{- define "MIDDLEWARE /t/{tenant}"}}{{.Resp.AddHeader "X-Tenant" (.Req.PathValue "tenant")}}{{end}}
+{{- define "GET /admin/panel"}}panel{{end}}
+{{- define "GET /t/{tenant}/home"}}home{{end}}
+{{- define "GET /other"}}other{{end}}`)},
+	}
+	instance, stats, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+	if stats.Middlewares != 3 {
+		t.Errorf("expected 3 middlewares, got %d", stats.Middlewares)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		chain  []string
+		body   string
+	}{
+		{"/other", 200, []string{"root"}, "other"},
+		{"/admin/panel?ok", 200, []string{"root", "admin"}, "panel"},
+		{"/admin/panel", 403, []string{"root", "admin"}, ""},
+		{"/t/acme/home", 200, []string{"root", "acme"}, "home"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		instance.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.path, test.status, w.Code)
+		}
+		var chain []string
+		for _, h := range []string{"X-Root", "X-Admin", "X-Tenant"} {
+			chain = append(chain, w.Header().Values(h)...)
+		}
+		if !slices.Equal(chain, test.chain) {
+			t.Errorf("%s: expected middleware chain %v, got %v", test.path, test.chain, chain)
+		}
+		if test.status == 200 && w.Body.String() != test.body {
+			t.Errorf("%s: expected body %q, got %q", test.path, test.body, w.Body)
+		}
+	}
+}
+
+func TestPatternShape(t *testing.T) {
+	tests := []struct {
+		hostpath string
+		want     string
+	}{
+		{"/items/{id}", "/items/{}"},
+		{"/items/{item}", "/items/{}"},
+		{"/files/{path...}", "/files/{...}"},
+		{"/a/{$}", "/a/{$}"},
+		{"example.com/{x}/b", "example.com/{}/b"},
+		{"/{a}/{b}", "/{}/{}"},
+	}
+	for _, test := range tests {
+		if got := patternShape(test.hostpath); got != test.want {
+			t.Errorf("patternShape(%q): expected %q, got %q", test.hostpath, test.want, got)
+		}
+	}
+}
+
+func TestOptionsRoutes(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		".items.html": {Data: []byte(`
+{{- define "GET /items/{id}"}}{{end}}
+{{- define "PUT /items/{id}"}}{{end}}
+{{- define "DELETE /items/{item}"}}{{end}}
+{{- define "POST /items"}}{{end}}
+{{- define "GET api.example.com/items"}}{{end}}
+{{- define "POST /custom"}}{{end}}
+{{- define "OPTIONS /custom"}}{{.Resp.SetHeader "Allow" "custom"}}{{end}}`)},
+	}
+	instance, _, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	tests := []struct {
+		url   string
+		allow string
+	}{
+		{"/items/1", "DELETE, GET, HEAD, OPTIONS, PUT"},
+		{"/items", "OPTIONS, POST"},
+		{"http://api.example.com/items", "GET, HEAD, OPTIONS"},
+		{"/custom", "custom"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		instance.ServeHTTP(w, httptest.NewRequest("OPTIONS", test.url, nil))
+		if got := w.Header().Get("Allow"); got != test.allow {
+			t.Errorf("OPTIONS %s: expected Allow %q, got %q", test.url, test.allow, got)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package xtemplate
+
+// This file finds problems in templates without serving them, see Config.Check.
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template/parse"
+)
+
+// Problem is an issue with the templates found by [Config.Check].
+type Problem struct {
+	// Severity is `error` for problems that prevent the templates from
+	// loading or would fail at runtime, or `warning` for suspicious but valid
+	// definitions.
+	Severity string `json:"severity"`
+	// Code identifies the kind of problem: `load`, `parse`, `route-collision`,
+	// `undefined-func`, `undefined-template`, `duplicate-template`,
+	// `missing-static-file`, or `undefined-dot-field`.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// File is the path of the template file relative to the templates root,
+	// starting with '/'.
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	// Template is the name of the template that contains the problem.
+	Template string `json:"template,omitempty"`
+}
+
+// Check builds an instance from the config like [Config.Instance] to find
+// problems with the templates without serving them. If the instance fails to
+// load, the error is reported as the only problem. Otherwise the parsed
+// templates are inspected for duplicate definitions, references to templates
+// that don't exist, `.X.StaticFileHash` calls with a literal path to a file
+// that doesn't exist, and dot fields that aren't configured. Like
+// [Config.Instance], dot providers are initialized and INIT templates are
+// executed, but scheduled templates and job workers are not started.
+func (config *Config) Check(cfgs ...Option) []Problem {
+	instance, _, _, err := config.Instance(append(slices.Clone(cfgs), WithoutBackground())...)
+	if err != nil {
+		return []Problem{loadProblem(err)}
+	}
+	defer instance.Shutdown(instance.config.Ctx)
+
+	c := &checker{instance: instance, problems: slices.Clone(instance.problems), fields: map[string]bool{}, routed: map[string]bool{}}
+	for _, name := range instance.routeTemplates {
+		c.routed[name] = true
+	}
+	for _, name := range []string{"X", "Req", "Resp", "Flush", "Error", "Test", "Job"} {
+		c.fields[name] = true
+	}
+	for _, p := range instance.providers {
+		c.fields[p.FieldName()] = true
+	}
+	for _, t := range instance.templates.Templates() {
+		c.checkTree(t.Name(), t.Tree, func(name string) bool { return instance.templates.Lookup(name) != nil })
+	}
+	for _, t := range instance.textTemplates.Templates() {
+		c.checkTree(t.Name(), t.Tree, func(name string) bool { return instance.textTemplates.Lookup(name) != nil })
+	}
+
+	slices.SortFunc(c.problems, func(a, b Problem) int {
+		return cmp.Or(
+			strings.Compare(a.File, b.File),
+			cmp.Compare(a.Line, b.Line),
+			cmp.Compare(a.Column, b.Column),
+			strings.Compare(a.Code, b.Code),
+			strings.Compare(a.Message, b.Message),
+			cmp.Compare(len(a.Template), len(b.Template)),
+		)
+	})
+	// templates copied for layouts repeat the problems of the original
+	return slices.CompactFunc(c.problems, func(a, b Problem) bool {
+		a.Template, b.Template = "", ""
+		return a == b
+	})
+}
+
+var undefinedFuncRegex = regexp.MustCompile(`function "[^"]*" not defined`)
+var routeCollisionRegex = regexp.MustCompile(`add handler to servemux '[^']*' from '([^']*)'`)
+
+// loadProblem describes an error returned while loading an instance.
+func loadProblem(err error) Problem {
+	p := Problem{Severity: "error", Code: "load", Message: err.Error()}
+	if matches := templateLocationRegex.FindAllStringSubmatch(p.Message, -1); len(matches) > 0 {
+		last := matches[len(matches)-1]
+		p.File = last[1]
+		p.Line, _ = strconv.Atoi(last[2])
+		p.Column, _ = strconv.Atoi(last[3])
+		p.Code = "parse"
+	}
+	switch {
+	case undefinedFuncRegex.MatchString(p.Message):
+		p.Code = "undefined-func"
+	case routeCollisionRegex.MatchString(p.Message):
+		p.Code = "route-collision"
+		p.File = routeCollisionRegex.FindStringSubmatch(p.Message)[1]
+	}
+	return p
+}
+
+type checker struct {
+	instance *Instance
+	problems []Problem
+	// fields are the names of the dot fields that are configured.
+	fields map[string]bool
+	// routed are the names of the templates that handle routes.
+	routed map[string]bool
+	// root reports whether the template being checked is executed with the
+	// dot value of a request, which is also `$` anywhere in the template.
+	root bool
+}
+
+// checkTree inspects the template named name. Templates that handle requests
+// or are executed by xtemplate, like INIT, ERROR, TEST, and JOB templates, are
+// known to be executed with the dot value of the request, so their use of dot
+// fields is checked too. Other templates can be called with any dot value.
+func (c *checker) checkTree(name string, tree *parse.Tree, defined func(string) bool) {
+	if tree == nil || tree.Root == nil {
+		return
+	}
+	c.root = isSpecialTemplateName(name) || c.routed[name]
+	c.walk(name, tree, tree.Root, c.root, defined)
+}
+
+func (c *checker) report(name string, tree *parse.Tree, node parse.Node, code, format string, args ...any) {
+	p := Problem{Severity: "error", Code: code, Message: fmt.Sprintf(format, args...), Template: name}
+	p.File, p.Line, p.Column = nodeLocation(tree, node)
+	c.problems = append(c.problems, p)
+}
+
+// nodeLocation returns the file, line, and column of node in tree.
+func nodeLocation(tree *parse.Tree, node parse.Node) (file string, line, col int) {
+	location, _ := tree.ErrorContext(node)
+	// location is formatted like `file:line:col`
+	if i := strings.LastIndex(location, ":"); i >= 0 {
+		col, _ = strconv.Atoi(location[i+1:])
+		location = location[:i]
+	}
+	if i := strings.LastIndex(location, ":"); i >= 0 {
+		line, _ = strconv.Atoi(location[i+1:])
+		file = location[:i]
+	}
+	return file, line, col
+}
+
+// walk inspects node and its children. root reports whether dot is the dot
+// value of the request at node.
+func (c *checker) walk(name string, tree *parse.Tree, node parse.Node, root bool, defined func(string) bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(name, tree, child, root, defined)
+		}
+	case *parse.ActionNode:
+		c.walk(name, tree, n.Pipe, root, defined)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			c.walk(name, tree, cmd, root, defined)
+		}
+	case *parse.CommandNode:
+		c.checkStaticFileHash(name, tree, n)
+		for _, arg := range n.Args {
+			c.walk(name, tree, arg, root, defined)
+		}
+	case *parse.ChainNode:
+		c.walk(name, tree, n.Node, root, defined)
+	case *parse.FieldNode:
+		if root && !c.fields[n.Ident[0]] {
+			c.report(name, tree, n, "undefined-dot-field", "dot field '.%s' is not configured", n.Ident[0])
+		}
+	case *parse.VariableNode:
+		if c.root && n.Ident[0] == "$" && len(n.Ident) > 1 && !c.fields[n.Ident[1]] {
+			c.report(name, tree, n, "undefined-dot-field", "dot field '$.%s' is not configured", n.Ident[1])
+		}
+	case *parse.IfNode:
+		c.walk(name, tree, n.Pipe, root, defined)
+		c.walk(name, tree, n.List, root, defined)
+		c.walk(name, tree, n.ElseList, root, defined)
+	case *parse.RangeNode:
+		// dot is the element inside range, and unchanged in else
+		c.walk(name, tree, n.Pipe, root, defined)
+		c.walk(name, tree, n.List, false, defined)
+		c.walk(name, tree, n.ElseList, root, defined)
+	case *parse.WithNode:
+		c.walk(name, tree, n.Pipe, root, defined)
+		c.walk(name, tree, n.List, false, defined)
+		c.walk(name, tree, n.ElseList, root, defined)
+	case *parse.TemplateNode:
+		// layouts are executed through copies scoped to each page where
+		// `content` refers to the next level, see pageTemplates
+		isLayout := path.Base(tree.ParseName) == ".layout"+c.instance.config.TemplateExtension
+		if !defined(n.Name) && !(isLayout && n.Name == layoutContentName) {
+			c.report(name, tree, n, "undefined-template", "template '%s' is not defined", n.Name)
+		}
+		c.walk(name, tree, n.Pipe, root, defined)
+	}
+}
+
+// checkStaticFileHash reports calls to `.X.StaticFileHash` with a literal path
+// to a file that doesn't exist.
+func (c *checker) checkStaticFileHash(name string, tree *parse.Tree, cmd *parse.CommandNode) {
+	if len(cmd.Args) < 2 {
+		return
+	}
+	var ident []string
+	switch fn := cmd.Args[0].(type) {
+	case *parse.FieldNode:
+		ident = fn.Ident
+	case *parse.VariableNode:
+		ident = fn.Ident
+	default:
+		return
+	}
+	if len(ident) < 2 || ident[len(ident)-1] != "StaticFileHash" || ident[len(ident)-2] != "X" {
+		return
+	}
+	arg, ok := cmd.Args[1].(*parse.StringNode)
+	if !ok {
+		return
+	}
+	if _, ok := c.instance.files[path.Clean("/"+arg.Text)]; !ok {
+		c.report(name, tree, arg, "missing-static-file", "static file '%s' does not exist", arg.Text)
+	}
+}
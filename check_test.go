@@ -0,0 +1,42 @@
+package xtemplate
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestCheck(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		".layout.html":      {Data: []byte(`<title>{{block "title" .}}Site{{end}}</title>{{template "content" .}}`)},
+		"index.html":        {Data: []byte(`{{define "title"}}Home{{end}}{{template "/.card.html" .X}}`)},
+		"about.html":        {Data: []byte(`{{define "title"}}About{{end}}{{.Missing}}`)},
+		"blog/.layout.html": {Data: []byte(`{{define "title"}}Blog{{end}}<main>{{template "content" .}}</main>`)},
+		"blog/post.html":    {Data: []byte(`{{define "title"}}Post{{end}}{{template "nowhere" .}}`)},
+		".card.html":        {Data: []byte(`{{.Name}} {{$.Title}}{{define "item"}}{{.ID}}{{end}}`)},
+		".shared.html":      {Data: []byte(`{{define "item"}}{{.Key}}{{end}}`)},
+		"jobs.html":         {Data: []byte(`{{define "NOLAYOUT"}}{{end}}{{define "JOB work"}}{{$.Queue}}{{end}}`)},
+		// error templates are scoped to their directory
+		"a/x.html": {Data: []byte(`{{define "ERROR 404"}}a {{.Error.Status}}{{end}}`)},
+		"b/y.html": {Data: []byte(`{{define "ERROR 404"}}b {{.Error.Status}}{{end}}`)},
+	}
+
+	type key struct{ code, file, template string }
+	want := map[key]bool{
+		{"undefined-dot-field", "/about.html", "/about.html"}:        true,
+		{"undefined-template", "/blog/post.html", "/blog/post.html"}: true,
+		{"duplicate-template", "/.shared.html", "item"}:              true,
+		{"undefined-dot-field", "/jobs.html", "JOB work"}:            true,
+	}
+	for _, p := range config.Check() {
+		k := key{p.Code, p.File, p.Template}
+		if !want[k] {
+			t.Errorf("unexpected problem: %+v", p)
+		}
+		delete(want, k)
+	}
+	for k := range want {
+		t.Errorf("expected problem %+v", k)
+	}
+}
@@ -26,15 +26,52 @@ type Config struct {
 	// File extension to search for to find template files. Default `.html`.
 	TemplateExtension string `json:"template_extension,omitempty" arg:"--template-ext" default:".html"`
 
+	// File extension of templates that are parsed with text/template instead
+	// of html/template, for generating content like xml, json, or csv. The
+	// extension is removed from the route path and the content type is
+	// determined by the remaining extension, so `sitemap.xml.tmpl` is served
+	// at `/sitemap.xml` as `application/xml`. Default `.tmpl`.
+	TextTemplateExtension string `json:"text_template_extension,omitempty" arg:"--text-template-ext" default:".tmpl"`
+
 	// Whether html templates are minified at load time. Default `true`.
 	Minify bool `json:"minify,omitempty" arg:"-m,--minify" default:"true"`
 
+	// Dev mode responds to template errors with a detailed error page that
+	// shows the template source, the error, the request, and the dot value.
+	// When a [Server] fails to reload, every request is answered with the
+	// load error until the next successful reload. Do not enable in
+	// production. Default `false`.
+	Dev bool `json:"dev,omitempty" arg:"--dev"`
+
+	// Run INIT templates only when a [Server] creates its first instance
+	// instead of every time it reloads. Reloaded instances keep the output of
+	// the first run for `.X.InitOutput`, and only run initializers that were
+	// added since. This only applies to instances created by a Server; calling
+	// [Config.Instance] directly always runs them.
+	// Default `false`.
+	InitOnce bool `json:"init_once,omitempty" arg:"--init-once"`
+
 	Databases       []DotDBConfig    `json:"databases" arg:"-"`
 	Flags           []DotFlagsConfig `json:"flags" arg:"-"`
 	Directories     []DotDirConfig   `json:"directories" arg:"-"`
 	Nats            []DotNatsConfig  `json:"nats" arg:"-"`
+	Jobs            []DotJobsConfig  `json:"jobs" arg:"-"`
 	CustomProviders []DotConfig      `json:"-" arg:"-"`
 
+	// Write a line to this file for every request served, or to stdout if
+	// `-`. Disabled if empty.
+	AccessLog string `json:"access_log,omitempty" arg:"--access-log"`
+
+	// The format of access log lines: `json`, `common`, or `combined`. The
+	// common and combined formats are the standard Common Log Format and
+	// Combined Log Format. The json format additionally includes the request
+	// id, matched route pattern, template name, and duration. Default `json`.
+	AccessLogFormat string `json:"access_log_format,omitempty" arg:"--access-log-format" default:"json"`
+
+	// IP addresses or CIDR ranges of reverse proxies whose X-Forwarded-For
+	// header is trusted to identify the client address in the access log.
+	TrustedProxies []string `json:"trusted_proxies,omitempty" arg:"--trusted-proxy,separate"`
+
 	// Left template action delimiter. Default `{{`.
 	LDelim string `json:"left,omitempty" arg:"--ldelim" default:"{{"`
 
@@ -50,6 +87,18 @@ type Config struct {
 
 	// The default logger. Defaults to `slog.Default()`.
 	Logger *slog.Logger `json:"-" arg:"-"`
+
+	// Metrics records request, template, dot provider, and reload metrics if
+	// not nil. See [NewMetrics].
+	Metrics *Metrics `json:"-" arg:"-"`
+
+	// Tracer records spans for requests, templates, and dot providers if not
+	// nil. See [NewTracer].
+	Tracer *Tracer `json:"-" arg:"-"`
+
+	// noBackground prevents instances from running scheduled templates and
+	// job workers, see WithoutBackground.
+	noBackground bool
 }
 
 // FillDefaults sets default values for unset fields
@@ -62,6 +111,14 @@ func (config *Config) Defaults() *Config {
 		config.TemplateExtension = ".html"
 	}
 
+	if config.TextTemplateExtension == "" {
+		config.TextTemplateExtension = ".tmpl"
+	}
+
+	if config.AccessLogFormat == "" {
+		config.AccessLogFormat = "json"
+	}
+
 	if config.LDelim == "" {
 		config.LDelim = "{{"
 	}
@@ -119,6 +176,21 @@ func WithFuncMaps(fm ...template.FuncMap) Option {
 	}
 }
 
+// WithoutBackground creates instances that don't run CRON and EVERY
+// templates or start job workers, for programs that load templates without
+// serving them like the check, export, and test subcommands. Jobs can still be
+// enqueued and are run by the instances that serve the site.
+func WithoutBackground() Option {
+	return func(c *Config) error {
+		c.noBackground = true
+		return nil
+	}
+}
+
+// WithProvider adds a custom dot provider. A provider passed as a pointer is
+// shared by every instance that a [Server] creates from the config, so it's
+// initialized by the first instance and shut down only when the last instance
+// that uses it is retired.
 func WithProvider(p DotConfig) Option {
 	return func(c *Config) error {
 		c.CustomProviders = append(c.CustomProviders, p)
@@ -0,0 +1,256 @@
+package xtemplate
+
+// This file renders the detailed error pages shown in dev mode, see Config.Dev.
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/tdewolff/minify/v2"
+)
+
+// devError is the data used to render a dev mode error page.
+type devError struct {
+	Title   string
+	Error   string
+	File    string
+	Line    int
+	Source  template.HTML
+	Chain   []string
+	Request *devRequest
+	Dot     []devField
+}
+
+type devRequest struct {
+	Method, URL, Proto, RemoteAddr string
+	Header                         [][2]string
+}
+
+type devField struct {
+	Name, Type, Value string
+}
+
+// sourceFunc returns the content of the template file at path as it was
+// parsed.
+type sourceFunc func(path string) (string, bool)
+
+// devSourceContextLines is the number of lines shown before and after the
+// line that caused the error.
+const devSourceContextLines = 5
+
+// devValueLimit truncates long dot field values.
+const devValueLimit = 2000
+
+// templateLocationRegex matches the `template: <name>:<line>:[<col>:]` prefix
+// of template parse and execution errors. The name is the file path or the
+// template name passed to Parse, which may contain spaces like `GET /x`.
+var templateLocationRegex = regexp.MustCompile(`template: ([^\n]+?):(\d+):(?:(\d+):)?`)
+
+// newDevError builds an error page for err. The file and line are taken from
+// the innermost template location mentioned in the error message, and the
+// source excerpt around it is looked up with sources. r and dot are optional.
+func newDevError(title string, err error, sources sourceFunc, r *http.Request, dot *reflect.Value) *devError {
+	e := &devError{Title: title, Error: err.Error(), Chain: errorChain(err)}
+
+	if matches := templateLocationRegex.FindAllStringSubmatch(e.Error, -1); len(matches) > 0 {
+		last := matches[len(matches)-1]
+		e.File = last[1]
+		e.Line, _ = strconv.Atoi(last[2])
+		if sources != nil {
+			if source, ok := sources(e.File); ok {
+				e.Source = highlightSource(source, e.Line)
+			}
+		}
+	}
+
+	if r != nil {
+		e.Request = &devRequest{Method: r.Method, URL: r.URL.String(), Proto: r.Proto, RemoteAddr: r.RemoteAddr}
+		names := make([]string, 0, len(r.Header))
+		for name := range r.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range r.Header[name] {
+				e.Request.Header = append(e.Request.Header, [2]string{name, value})
+			}
+		}
+	}
+
+	if dot != nil && dot.IsValid() {
+		typ := dot.Type()
+		for i := 0; i < dot.NumField(); i++ {
+			field := dot.Field(i)
+			value := "<nil>"
+			if field.IsValid() && field.CanInterface() {
+				value = fmt.Sprintf("%+v", field.Interface())
+			}
+			if len(value) > devValueLimit {
+				value = value[:devValueLimit] + "…"
+			}
+			e.Dot = append(e.Dot, devField{typ.Field(i).Name, typ.Field(i).Type.String(), value})
+		}
+	}
+
+	return e
+}
+
+// errorChain lists the messages of err and every error it wraps, including
+// each branch of joined errors, outermost first.
+func errorChain(err error) []string {
+	var chain []string
+	var walk func(error)
+	walk = func(err error) {
+		if err == nil {
+			return
+		}
+		chain = append(chain, fmt.Sprintf("%s (%T)", err.Error(), err))
+		switch u := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, err := range u.Unwrap() {
+				walk(err)
+			}
+		default:
+			walk(errors.Unwrap(err))
+		}
+	}
+	walk(err)
+	return chain
+}
+
+// highlightSource renders the lines of source around line as syntax
+// highlighted html with line numbers, emphasizing line.
+func highlightSource(source string, line int) template.HTML {
+	lines := strings.SplitAfter(source, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	start := max(line-devSourceContextLines, 1)
+	end := min(line+devSourceContextLines, len(lines))
+	excerpt := strings.Join(lines[start-1:end], "")
+
+	lexer := lexers.Get("go-html-template")
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	iterator, err := lexer.Tokenise(nil, excerpt)
+	if err != nil {
+		return template.HTML("<pre>" + template.HTMLEscapeString(excerpt) + "</pre>")
+	}
+	formatter := chromahtml.New(
+		chromahtml.WithLineNumbers(true),
+		chromahtml.BaseLineNumber(start),
+		chromahtml.HighlightLines([][2]int{{line, line}}),
+	)
+	var b strings.Builder
+	if err := formatter.Format(&b, styles.Get("github"), iterator); err != nil {
+		return template.HTML("<pre>" + template.HTMLEscapeString(excerpt) + "</pre>")
+	}
+	return template.HTML(b.String())
+}
+
+// writeDevError responds to the request with the error page.
+func writeDevError(w http.ResponseWriter, e *devError) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	devErrorTemplate.Execute(w, e)
+}
+
+// sourceFunc returns a sourceFunc that looks up templates parsed by this
+// instance.
+func (instance *Instance) sourceFunc() sourceFunc {
+	return func(path string) (string, bool) {
+		source, ok := instance.sources[path]
+		return source, ok
+	}
+}
+
+// loadSourceFunc returns a sourceFunc that reads templates from the FS
+// configured in config, for showing errors that occurred while loading an
+// instance.
+func loadSourceFunc(config *Config) sourceFunc {
+	fsys := config.TemplatesFS
+	if fsys == nil {
+		fsys = os.DirFS(config.TemplatesDir)
+	}
+	var m *minify.M
+	if config.Minify {
+		m = newMinifier(config)
+	}
+	return func(path string) (string, bool) {
+		path = strings.TrimPrefix(path, "/")
+		if !fs.ValidPath(path) {
+			return "", false
+		}
+		m := m
+		if config.TextTemplateExtension != "" && strings.HasSuffix(path, config.TextTemplateExtension) {
+			// text templates are not minified at load time
+			m = nil
+		}
+		source, err := readTemplate(fsys, m, path)
+		return source, err == nil
+	}
+}
+
+var devErrorTemplate = template.Must(template.New("deverror").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; color: #24292f; }
+h1 { color: #cf222e; font-size: 1.5rem; }
+h2 { font-size: 1.1rem; margin-top: 2rem; border-bottom: 1px solid #d0d7de; }
+pre, code, td.mono { font-family: ui-monospace, monospace; font-size: 0.85rem; }
+pre.error { background: #fff1f0; border: 1px solid #ffc1c0; padding: 1rem; white-space: pre-wrap; }
+div.source pre { padding: 0.5rem; border: 1px solid #d0d7de; overflow-x: auto; }
+table { border-collapse: collapse; }
+td { padding: 0.2rem 0.8rem 0.2rem 0; vertical-align: top; }
+td.value { white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<pre class="error">{{.Error}}</pre>
+{{- if .File}}
+<h2>{{.File}}{{if .Line}}:{{.Line}}{{end}}</h2>
+{{- if .Source}}<div class="source">{{.Source}}</div>{{end}}
+{{- end}}
+<h2>Error chain</h2>
+<ol>{{range .Chain}}<li><code>{{.}}</code></li>{{end}}</ol>
+{{- with .Request}}
+<h2>Request</h2>
+<table>
+<tr><td>Method</td><td class="mono">{{.Method}}</td></tr>
+<tr><td>URL</td><td class="mono">{{.URL}}</td></tr>
+<tr><td>Proto</td><td class="mono">{{.Proto}}</td></tr>
+<tr><td>Remote address</td><td class="mono">{{.RemoteAddr}}</td></tr>
+{{- range .Header}}
+<tr><td>{{index . 0}}</td><td class="mono value">{{index . 1}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Dot}}
+<h2>Dot</h2>
+<table>
+{{- range .Dot}}
+<tr><td class="mono">.{{.Name}}</td><td class="mono">{{.Type}}</td><td class="mono value">{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,58 @@
+package xtemplate
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDevErrorLocation(t *testing.T) {
+	sources := func(path string) (string, bool) {
+		return "line 1\nline 2\nline 3\n", true
+	}
+	tests := []struct {
+		err  string
+		file string
+		line int
+	}{
+		{`template: /index.html:3:12: executing "/index.html" at <.X.Nope>: can't evaluate field Nope`, "/index.html", 3},
+		{`template: GET /contact/{id}:2:4: executing "GET /contact/{id}" at <failf "x">: error calling failf: x`, "GET /contact/{id}", 2},
+		{`template: /my page.html:1: unexpected "}" in operand`, "/my page.html", 1},
+		// the innermost location is used
+		{`template: /a.html:1:2: executing "/a.html" at <.X.Template>: error calling Template: failed to execute template 'row': template: /rows/row.html:3:5: executing "row" at <.x>: nil`, "/rows/row.html", 3},
+		{`no location here`, "", 0},
+	}
+	for _, test := range tests {
+		e := newDevError("Error", errors.New(test.err), sources, nil, nil)
+		if e.File != test.file || e.Line != test.line {
+			t.Errorf("%s: expected %s:%d, got %s:%d", test.err, test.file, test.line, e.File, e.Line)
+		}
+		if test.file != "" && e.Source == "" {
+			t.Errorf("%s: expected source excerpt", test.err)
+		}
+	}
+}
+
+func TestDevErrorPage(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.Dev = true
+	config.TemplatesFS = fstest.MapFS{
+		"contact.html": {Data: []byte("{{define \"GET /contact/{id}\"}}\n<p>\n{{failf \"no contact %s\" (.Req.PathValue \"id\")}}\n</p>\n{{end}}")},
+	}
+	instance, _, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+	w := httptest.NewRecorder()
+	instance.ServeHTTP(w, httptest.NewRequest("GET", "/contact/7", nil))
+	body := w.Body.String()
+	for _, want := range []string{"no contact 7", "/contact.html:3"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected dev error page to contain %q, got:\n%s", want, body)
+		}
+	}
+}
@@ -27,6 +27,29 @@ type CleanupDotProvider interface {
 	Cleanup(any, error) error
 }
 
+// ShutdownDotProvider is an optional interface that a [DotConfig] can
+// implement to release resources acquired in Init, like database connection
+// pools or network connections. Shutdown is called once when the [Instance]
+// that owns the provider is retired, after all of its requests have finished.
+type ShutdownDotProvider interface {
+	DotConfig
+	Shutdown(context.Context) error
+}
+
+// HandoffDotProvider is an optional interface that a [DotConfig] can implement
+// to reuse the live resources of the provider with the same field name in the
+// previous instance when a [Server] reloads, instead of calling Init again.
+// Handoff is only called if the json encoding of both providers before Init is
+// identical. It should copy the resources from old and return true, or return
+// false to fall back to Init. After a successful handoff the old provider is
+// not shut down when its instance is retired; its resources are shut down with
+// the new instance instead. Providers that are configured with fields that are
+// excluded from json should compare them in Handoff.
+type HandoffDotProvider interface {
+	DotConfig
+	Handoff(old DotConfig) bool
+}
+
 func makeDot(dps []DotConfig) dot {
 	fields := make([]reflect.StructField, 0, len(dps))
 	cleanups := []cleanup{}
@@ -78,9 +101,27 @@ func (d *dot) value(sctx context.Context, w http.ResponseWriter, r *http.Request
 		}
 		val.Field(i).Set(reflect.ValueOf(a))
 	}
+	for i := range d.dps {
+		if b, ok := val.Field(i).Interface().(dotBinder); ok {
+			b.bind(func(name string) any {
+				if f := val.FieldByName(name); f.IsValid() {
+					return f.Interface()
+				}
+				return nil
+			})
+		}
+	}
 	return
 }
 
+// dotBinder is implemented by dot values that use the values of other dot
+// fields in the same template execution, like the jobs queue that adds jobs
+// in the transaction of its database field. bind is called after every field
+// is constructed, with a function that returns the value of a field by name.
+type dotBinder interface {
+	bind(field func(name string) any)
+}
+
 func (d *dot) cleanup(v *reflect.Value, err error) error {
 	for _, cleanup := range d.cleanups {
 		err = cleanup.Cleanup(v.Field(cleanup.idx).Interface(), err)
@@ -20,6 +20,10 @@ type DotDB struct {
 	ctx context.Context
 	opt *sql.TxOptions
 	tx  *sql.Tx
+
+	name     string
+	metrics  *Metrics
+	migrator *dbMigrator
 }
 
 func (d *DotDB) makeTx() (err error) {
@@ -37,8 +41,11 @@ func (c *DotDB) Exec(query string, params ...any) (result sql.Result, err error)
 		return
 	}
 
+	span := startSpan(c.ctx, "DB.Exec", "db.name", c.name, "db.statement", query)
 	defer func(start time.Time) {
 		c.log.Debug("Exec", slog.String("query", query), slog.Any("params", params), slog.Any("error", err), slog.Duration("queryduration", time.Since(start)))
+		c.metrics.observeQuery(c.name, "Exec", time.Since(start), err)
+		span.end(err)
 	}(time.Now())
 
 	return c.tx.Exec(query, params...)
@@ -50,8 +57,12 @@ func (c *DotDB) QueryRows(query string, params ...any) (rows []map[string]any, e
 		return
 	}
 
+	span := startSpan(c.ctx, "DB.QueryRows", "db.name", c.name, "db.statement", query)
 	defer func(start time.Time) {
 		c.log.Debug("QueryRows", slog.String("query", query), slog.Any("params", params), slog.Any("error", err), slog.Duration("queryduration", time.Since(start)))
+		c.metrics.observeQuery(c.name, "QueryRows", time.Since(start), err)
+		span.SetAttr("db.rows", len(rows))
+		span.end(err)
 	}(time.Now())
 
 	result, err := c.tx.Query(query, params...)
@@ -116,6 +127,63 @@ func (c *DotDB) QueryVal(query string, params ...any) (any, error) {
 	panic("impossible condition")
 }
 
+// Migrate applies the migrations that haven't been applied, up to and
+// including the version target if it's given. Applied migrations after
+// target are reverted with their down migration files in reverse order, so
+// `.DB.Migrate 0` reverts every migration. It returns the number of
+// migrations applied or reverted. Each migration runs in its own transaction
+// on a separate connection from the queries of the template, see
+// [DotDBConfig.Migrations].
+func (c *DotDB) Migrate(target ...int64) (count int, err error) {
+	if c.migrator == nil {
+		return 0, fmt.Errorf("database '%s' has no migrations configured", c.name)
+	}
+	if len(target) > 1 {
+		return 0, fmt.Errorf("expected at most one target version, got %d", len(target))
+	}
+	version := int64(-1)
+	if len(target) == 1 {
+		if version = target[0]; version < 0 {
+			return 0, fmt.Errorf("target version must not be negative, got %d", version)
+		}
+	}
+
+	span := startSpan(c.ctx, "DB.Migrate", "db.name", c.name)
+	defer func(start time.Time) {
+		c.log.Debug("Migrate", slog.Int64("target", version), slog.Int("count", count), slog.Any("error", err), slog.Duration("duration", time.Since(start)))
+		span.SetAttr("db.migrations", count)
+		span.end(err)
+	}(time.Now())
+
+	return c.migrator.migrate(c.ctx, version, c.log)
+}
+
+// MigrationVersion returns the highest version of the applied migrations, or
+// 0 if none are applied.
+func (c *DotDB) MigrationVersion() (int64, error) {
+	if c.migrator == nil {
+		return 0, fmt.Errorf("database '%s' has no migrations configured", c.name)
+	}
+	applied, err := c.migrator.applied(c.ctx)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version, nil
+}
+
+// MigrationStatus lists the migration files in order of version and whether
+// each one has been applied.
+func (c *DotDB) MigrationStatus() ([]MigrationStatus, error) {
+	if c.migrator == nil {
+		return nil, fmt.Errorf("database '%s' has no migrations configured", c.name)
+	}
+	return c.migrator.status(c.ctx)
+}
+
 // Commit manually commits any implicit transactions opened by this DotDB. This
 // is called automatically if there were no errors at the end of template
 // execution.
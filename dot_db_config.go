@@ -5,6 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
 )
 
 func WithDB(name string, db *sql.DB, opt *sql.TxOptions) Option {
@@ -17,6 +23,18 @@ func WithDB(name string, db *sql.DB, opt *sql.TxOptions) Option {
 	}
 }
 
+// memoryDBCounter makes the names of in-memory databases unique.
+var memoryDBCounter atomic.Int64
+
+// MemoryDBConnstr returns the connection string of a new, empty, in-memory
+// sqlite database for the sqlite3 driver. Every connection of the sql.DB that
+// opens it shares the same database, which is removed when the last
+// connection is closed. The program must register the sqlite3 driver, e.g. by
+// importing github.com/mattn/go-sqlite3.
+func MemoryDBConnstr() string {
+	return fmt.Sprintf("file:xtemplate-memory-%d?mode=memory&cache=shared", memoryDBCounter.Add(1))
+}
+
 type DotDBConfig struct {
 	*sql.DB        `json:"-"`
 	*sql.TxOptions `json:"-"`
@@ -24,14 +42,38 @@ type DotDBConfig struct {
 	Driver         string `json:"driver"`
 	Connstr        string `json:"connstr"`
 	MaxOpenConns   int    `json:"max_open_conns"`
+
+	// Migrations is the path of a directory of sql migration files that are
+	// applied in order of their version when the database is initialized, see
+	// [DotDB.Migrate]. Each file runs in a transaction, but mysql commits
+	// schema changes implicitly, and files with several statements need the
+	// `multiStatements=true` connection parameter.
+	Migrations string `json:"migrations,omitempty"`
+	// MigrationsFS is the directory of migration files. Overrides Migrations
+	// if not nil.
+	MigrationsFS fs.FS `json:"-"`
+	// MigrationsPattern matches the names of migration files, and its first
+	// subgroup is the version number. Files named like `<name>.down.sql`
+	// revert the migration whose file name is `<name>.sql` or
+	// `<name>.up.sql`. Default `^(\d+).*\.sql$`.
+	MigrationsPattern string `json:"migrations_pattern,omitempty"`
+	// MigrationsTable is the name of the table that records applied
+	// migrations, which is created if it doesn't exist. Default
+	// `xtemplate_migrations`.
+	MigrationsTable string `json:"migrations_table,omitempty"`
+
+	opened   bool
+	migrator *dbMigrator
 }
 
 var _ CleanupDotProvider = &DotDBConfig{}
+var _ ShutdownDotProvider = &DotDBConfig{}
+var _ HandoffDotProvider = &DotDBConfig{}
 
 func (d *DotDBConfig) FieldName() string { return d.Name }
 func (d *DotDBConfig) Init(ctx context.Context) error {
 	if d.DB != nil {
-		return nil
+		return d.migrate(ctx)
 	}
 	db, err := sql.Open(d.Driver, d.Connstr)
 	if err != nil {
@@ -39,13 +81,58 @@ func (d *DotDBConfig) Init(ctx context.Context) error {
 	}
 	db.SetMaxOpenConns(d.MaxOpenConns)
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return fmt.Errorf("failed to ping database on open: %w", err)
 	}
 	d.DB = db
+	d.opened = true
+	if err := d.migrate(ctx); err != nil {
+		d.Shutdown(ctx)
+		return err
+	}
 	return nil
 }
+
+const defaultMigrationsPattern = `^(\d+).*\.sql$`
+
+// migrate applies the pending migrations if Migrations or MigrationsFS is set.
+func (d *DotDBConfig) migrate(ctx context.Context) error {
+	if d.Migrations == "" && d.MigrationsFS == nil {
+		return nil
+	}
+	fsys := d.MigrationsFS
+	if fsys == nil {
+		fsys = os.DirFS(d.Migrations)
+	}
+	if d.MigrationsPattern == "" {
+		d.MigrationsPattern = defaultMigrationsPattern
+	}
+	pattern, err := regexp.Compile(d.MigrationsPattern)
+	if err != nil {
+		return fmt.Errorf("invalid migrations pattern for database '%s': %w", d.Name, err)
+	}
+	if pattern.NumSubexp() < 1 {
+		return fmt.Errorf("migrations pattern for database '%s' must have a subgroup that matches the version: '%s'", d.Name, d.MigrationsPattern)
+	}
+	if d.MigrationsTable == "" {
+		d.MigrationsTable = "xtemplate_migrations"
+	}
+	if !sqlIdentifierRegex.MatchString(d.MigrationsTable) {
+		return fmt.Errorf("invalid migrations table name '%s'", d.MigrationsTable)
+	}
+	m := &dbMigrator{db: d.DB, fsys: fsys, pattern: pattern, table: d.MigrationsTable, dialect: d.dialect()}
+	if err := m.init(ctx); err != nil {
+		return fmt.Errorf("failed to create migrations table for database '%s': %w", d.Name, err)
+	}
+	if _, err := m.migrate(ctx, -1, GetLogger(ctx).With(slog.String("database", d.Name))); err != nil {
+		return fmt.Errorf("failed to migrate database '%s': %w", d.Name, err)
+	}
+	d.migrator = m
+	return nil
+}
+
 func (d *DotDBConfig) Value(r Request) (any, error) {
-	return &DotDB{d.DB, GetLogger(r.R.Context()), r.R.Context(), d.TxOptions, nil}, nil
+	return &DotDB{d.DB, GetLogger(r.R.Context()), r.R.Context(), d.TxOptions, nil, d.Name, getMetrics(r.R.Context()), d.migrator}, nil
 }
 func (dp *DotDBConfig) Cleanup(v any, err error) error {
 	d := v.(*DotDB)
@@ -55,3 +142,48 @@ func (dp *DotDBConfig) Cleanup(v any, err error) error {
 		return errors.Join(err, d.commit())
 	}
 }
+
+// dialect returns the SQL dialect of the database: `postgres`, `mysql`, or
+// `sqlite`, determined by the driver name or the type of the driver of a DB
+// passed in with [WithDB].
+func (d *DotDBConfig) dialect() string {
+	name := d.Driver
+	if name == "" && d.DB != nil {
+		name = fmt.Sprintf("%T", d.DB.Driver())
+	}
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "postgres") || strings.Contains(name, "pgx") || strings.Contains(name, "pq.") || strings.Contains(name, "stdlib."):
+		return "postgres"
+	case strings.Contains(name, "mysql"):
+		return "mysql"
+	}
+	return "sqlite"
+}
+
+// Shutdown closes the database if it was opened by Init. A DB passed in with
+// [WithDB] is owned by the caller and is left open.
+func (d *DotDBConfig) Shutdown(ctx context.Context) error {
+	if !d.opened {
+		return nil
+	}
+	return d.DB.Close()
+}
+
+// Handoff reuses the database of the previous instance if it is configured
+// with the same options. Migration files may have been added since the
+// previous instance was created, so pending migrations are applied; if that
+// fails the database is not handed off and Init reports the error.
+func (d *DotDBConfig) Handoff(old DotConfig) bool {
+	o, ok := old.(*DotDBConfig)
+	if !ok || o.DB == nil || (d.DB != nil && d.DB != o.DB) || d.TxOptions != o.TxOptions {
+		return false
+	}
+	db := d.DB
+	d.DB, d.opened = o.DB, o.opened
+	if err := d.migrate(context.Background()); err != nil {
+		d.DB, d.opened = db, false
+		return false
+	}
+	return true
+}
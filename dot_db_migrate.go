@@ -0,0 +1,239 @@
+package xtemplate
+
+// Migrations are sql files in the directory configured by
+// DotDBConfig.Migrations that are applied in order of their version number and
+// recorded in a tracking table.
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MigrationStatus describes a migration file and whether it has been applied,
+// see [DotDB.MigrationStatus].
+type MigrationStatus struct {
+	Version int64
+	// Name is the name of the file that migrates up.
+	Name string
+	// Down is the name of the file that reverts the migration, or empty if
+	// it can't be reverted.
+	Down      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migration is a migration file found in the migrations directory.
+type migration struct {
+	version  int64
+	up, down string
+}
+
+// dbMigrator applies the migrations in fsys to db and records them in table.
+type dbMigrator struct {
+	db      *sql.DB
+	fsys    fs.FS
+	pattern *regexp.Regexp
+	table   string
+	dialect string
+}
+
+// init creates the tracking table if it doesn't exist.
+func (m *dbMigrator) init(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+m.table+` (
+	version BIGINT NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at BIGINT NOT NULL
+)`)
+	return err
+}
+
+// query rewrites the `?` placeholders of s for the dialect of the database.
+func (m *dbMigrator) query(s string) string {
+	return rebindPlaceholders(m.dialect, s)
+}
+
+// list returns the migration files in order of version. A file is a
+// migration if its name matches the pattern, whose first subgroup is the
+// version. Files named like `<name>.down.sql` revert the migration in the file
+// that matches the pattern with `.down` removed, and `<name>.up.sql` is the
+// same as `<name>.sql`.
+func (m *dbMigrator) list() ([]migration, error) {
+	entries, err := fs.ReadDir(m.fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+	byVersion := map[int64]*migration{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name, down := e.Name(), false
+		if before, after, ok := strings.Cut(name, ".down."); ok {
+			name, down = before+"."+after, true
+		} else if before, after, ok := strings.Cut(name, ".up."); ok {
+			name = before + "." + after
+		}
+		matches := m.pattern.FindStringSubmatch(name)
+		if len(matches) < 2 {
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration file '%s' must have a positive version number, got '%s'", e.Name(), matches[1])
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{version: version}
+			byVersion[version] = mig
+		}
+		file := &mig.up
+		if down {
+			file = &mig.down
+		}
+		if *file != "" {
+			return nil, fmt.Errorf("migration version %d is defined by both '%s' and '%s'", version, *file, e.Name())
+		}
+		*file = e.Name()
+	}
+	var migrations []migration
+	for _, mig := range byVersion {
+		if mig.up == "" {
+			return nil, fmt.Errorf("down migration '%s' has no matching up migration", mig.down)
+		}
+		migrations = append(migrations, *mig)
+	}
+	slices.SortFunc(migrations, func(a, b migration) int { return cmp.Compare(a.version, b.version) })
+	return migrations, nil
+}
+
+// applied returns the time that each applied migration version was applied.
+func (m *dbMigrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT version, applied_at FROM `+m.table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version, at int64
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = time.UnixMilli(at)
+	}
+	return applied, rows.Err()
+}
+
+// status returns every migration file and whether it's applied.
+func (m *dbMigrator) status(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := m.list()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(migrations))
+	for i, mig := range migrations {
+		at, ok := applied[mig.version]
+		status[i] = MigrationStatus{Version: mig.version, Name: mig.up, Down: mig.down, Applied: ok, AppliedAt: at}
+	}
+	return status, nil
+}
+
+// migrate applies every migration up to and including target that hasn't been
+// applied in order of version, then reverts every applied migration after
+// target in reverse order. A negative target means the latest version. Each
+// migration runs in its own transaction together with the update to the
+// tracking table. It returns the number of migrations applied or reverted.
+func (m *dbMigrator) migrate(ctx context.Context, target int64, log *slog.Logger) (int, error) {
+	migrations, err := m.list()
+	if err != nil {
+		return 0, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	byVersion := map[int64]migration{}
+	for _, mig := range migrations {
+		byVersion[mig.version] = mig
+	}
+
+	count := 0
+	for _, mig := range migrations {
+		if _, ok := applied[mig.version]; ok || (target >= 0 && mig.version > target) {
+			continue
+		}
+		if err := m.apply(ctx, mig.version, mig.up, false); err != nil {
+			return count, err
+		}
+		log.Info("applied migration", slog.String("table", m.table), slog.Int64("version", mig.version), slog.String("file", mig.up))
+		count += 1
+	}
+
+	if target < 0 {
+		return count, nil
+	}
+	var revert []int64
+	for version := range applied {
+		if version > target {
+			revert = append(revert, version)
+		}
+	}
+	slices.Sort(revert)
+	slices.Reverse(revert)
+	for _, version := range revert {
+		mig, ok := byVersion[version]
+		if !ok || mig.down == "" {
+			return count, fmt.Errorf("cannot revert migration version %d because it has no down migration file", version)
+		}
+		if err := m.apply(ctx, version, mig.down, true); err != nil {
+			return count, err
+		}
+		log.Info("reverted migration", slog.String("table", m.table), slog.Int64("version", version), slog.String("file", mig.down))
+		count += 1
+	}
+	return count, nil
+}
+
+// apply executes the statements in file and records that the migration
+// version was applied, or removes it if down is true, in one transaction.
+func (m *dbMigrator) apply(ctx context.Context, version int64, file string, down bool) (err error) {
+	stmt, err := fs.ReadFile(m.fsys, file)
+	if err != nil {
+		return fmt.Errorf("failed to read migration file '%s': %w", file, err)
+	}
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(fmt.Errorf("failed to apply migration '%s': %w", file, err), tx.Rollback())
+		}
+	}()
+	if _, err = tx.ExecContext(ctx, string(stmt)); err != nil {
+		return err
+	}
+	if down {
+		_, err = tx.ExecContext(ctx, m.query(`DELETE FROM `+m.table+` WHERE version = ?`), version)
+	} else {
+		_, err = tx.ExecContext(ctx, m.query(`INSERT INTO `+m.table+` (version, name, applied_at) VALUES (?, ?, ?)`), version, file, time.Now().UnixMilli())
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,140 @@
+package xtemplate
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations := fstest.MapFS{
+		"1_users.sql":         {Data: []byte("CREATE TABLE users (name TEXT);")},
+		"1_users.down.sql":    {Data: []byte("DROP TABLE users;")},
+		"2_seed.up.sql":       {Data: []byte("INSERT INTO users VALUES ('ann');")},
+		"2_seed.down.sql":     {Data: []byte("DELETE FROM users;")},
+		"10_index.sql":        {Data: []byte("CREATE INDEX users_name ON users (name);")},
+		"README.md":           {Data: []byte("not a migration")},
+		"10_index.down.sql":   {Data: []byte("DROP INDEX users_name;")},
+		"notes/3_ignored.sql": {Data: []byte("invalid")},
+	}
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"migrate.html": {Data: []byte(`
+{{- define "GET /status"}}{{.DB.MigrationVersion}}:{{range .DB.MigrationStatus}} {{.Version}}={{.Applied}}{{end}}{{end}}
+{{- define "POST /migrate/{version}"}}{{.DB.Migrate (atoi (.Req.PathValue "version") | int64)}}{{end}}
+{{- define "POST /migrate"}}{{.DB.Migrate}}{{end}}
+{{- define "GET /users"}}{{.DB.QueryVal "SELECT COUNT(*) FROM users"}}{{end}}`)},
+	}
+	config.Databases = []DotDBConfig{{Name: "DB", Driver: "sqlite3", Connstr: "file:migrations_test?mode=memory&cache=shared", MigrationsFS: migrations}}
+
+	instance, _, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	request := func(method, path string) string {
+		w := httptest.NewRecorder()
+		instance.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if w.Code != 200 {
+			t.Fatalf("%s %s: expected status 200, got %d: %s", method, path, w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+
+	if got := request("GET", "/status"); got != "10: 1=true 2=true 10=true" {
+		t.Errorf("expected every migration to be applied at init, got %q", got)
+	}
+	if got := request("GET", "/users"); got != "1" {
+		t.Errorf("expected seeded user, got %q", got)
+	}
+	if got := request("POST", "/migrate/1"); got != "2" {
+		t.Errorf("expected 2 migrations to be reverted, got %q", got)
+	}
+	if got := request("GET", "/status"); got != "1: 1=true 2=false 10=false" {
+		t.Errorf("expected migrations after version 1 to be reverted, got %q", got)
+	}
+	if got := request("GET", "/users"); got != "0" {
+		t.Errorf("expected seed to be reverted, got %q", got)
+	}
+	if got := request("POST", "/migrate"); got != "2" {
+		t.Errorf("expected 2 migrations to be applied, got %q", got)
+	}
+	if got := request("GET", "/status"); got != "10: 1=true 2=true 10=true" {
+		t.Errorf("expected every migration to be applied again, got %q", got)
+	}
+}
+
+func TestMigrationFailureRollsBack(t *testing.T) {
+	migrations := fstest.MapFS{
+		"1_users.sql":  {Data: []byte("CREATE TABLE users (name TEXT);")},
+		"2_broken.sql": {Data: []byte("CREATE TABLE broken (name TEXT); SELECT missing FROM nowhere;")},
+	}
+	config := New()
+	config.TemplatesFS = fstest.MapFS{}
+	config.Databases = []DotDBConfig{{Name: "DB", Driver: "sqlite3", Connstr: "file:migrations_failure_test?mode=memory&cache=shared", MigrationsFS: migrations}}
+
+	// keep the in-memory database alive between instances
+	keep := DotDBConfig{Driver: "sqlite3", Connstr: config.Databases[0].Connstr}
+	if err := keep.Init(config.Ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer keep.Shutdown(config.Ctx)
+
+	_, _, _, err := config.Instance()
+	if err == nil || !strings.Contains(err.Error(), "failed to apply migration '2_broken.sql'") {
+		t.Fatalf("expected migration failure, got %v", err)
+	}
+	var count int
+	if err := keep.DB.QueryRow("SELECT COUNT(*) FROM xtemplate_migrations").Scan(&count); err != nil || count != 1 {
+		t.Errorf("expected only the first migration to be recorded, got %d: %v", count, err)
+	}
+	if err := keep.DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'broken'").Scan(&count); err != nil || count != 0 {
+		t.Errorf("expected the failed migration to be rolled back, got %d tables: %v", count, err)
+	}
+}
+
+func TestListMigrations(t *testing.T) {
+	tests := []struct {
+		desc  string
+		files []string
+		want  string
+		error string
+	}{
+		{"sorted by version", []string{"10_c.sql", "2_b.sql", "1_a.sql"}, "1:1_a.sql: 2:2_b.sql: 10:10_c.sql:", ""},
+		{"up and down", []string{"1_a.up.sql", "1_a.down.sql"}, "1:1_a.up.sql:1_a.down.sql", ""},
+		{"duplicate version", []string{"1_a.sql", "1_b.sql"}, "", "is defined by both"},
+		{"down without up", []string{"1_a.down.sql"}, "", "has no matching up migration"},
+		{"zero version", []string{"0_a.sql"}, "", "must have a positive version"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, f := range test.files {
+				fsys[f] = &fstest.MapFile{}
+			}
+			m := &dbMigrator{fsys: fsys, pattern: regexp.MustCompile(defaultMigrationsPattern)}
+			migrations, err := m.list()
+			if test.error != "" {
+				if err == nil || !strings.Contains(err.Error(), test.error) {
+					t.Fatalf("expected error containing %q, got %v", test.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, mig := range migrations {
+				got = append(got, strings.Join([]string{strconv.FormatInt(mig.version, 10), mig.up, mig.down}, ":"))
+			}
+			if strings.Join(got, " ") != test.want {
+				t.Errorf("expected %q, got %q", test.want, strings.Join(got, " "))
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package xtemplate
+
+import (
+	"context"
+	"net/http"
+)
+
+type dotErrorProvider struct{}
+
+func (dotErrorProvider) FieldName() string            { return "Error" }
+func (dotErrorProvider) Init(_ context.Context) error { return nil }
+func (dotErrorProvider) Value(r Request) (any, error) {
+	e, _ := r.R.Context().Value(dotErrorKey).(DotError)
+	return e, nil
+}
+
+var _ DotConfig = dotErrorProvider{}
+
+type dotErrorType struct{}
+
+var dotErrorKey = dotErrorType{}
+
+// DotError is used as the .Error field in error templates, which are
+// templates named like `ERROR 404` or `ERROR *`. They are executed in place of
+// the default plain text response when a template fails, returns an
+// [ErrorStatus], or no route matches the request. The error template for a
+// request is looked up starting from the directory of the request path up to
+// the root, using the first directory that defines an error template for the
+// exact status or, failing that, `ERROR *`. Error templates are executed with
+// the same dot fields as buffered templates plus .Error, so .Req is the
+// original request. The response status defaults to Status.
+type DotError struct {
+	// Status is the http status code of the response.
+	Status int
+	// Err is the error that caused the response, or nil if the status was not
+	// caused by an error, e.g. when no route matched the request.
+	Err error
+}
+
+// StatusText returns the standard text for the status code, like `Not Found`.
+func (e DotError) StatusText() string {
+	return http.StatusText(e.Status)
+}
+
+// Message returns the error message, or the status text if there is no error.
+func (e DotError) Message() string {
+	if e.Err == nil {
+		return e.StatusText()
+	}
+	return e.Err.Error()
+}
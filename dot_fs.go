@@ -2,6 +2,7 @@ package xtemplate
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
@@ -12,6 +13,7 @@ import (
 type dotFS struct {
 	fs     fs.FS
 	log    *slog.Logger
+	ctx    context.Context
 	opened map[fs.File]struct{}
 }
 
@@ -64,9 +66,12 @@ func (d Dir) Stat(name string) (fs.FileInfo, error) {
 }
 
 // Read returns the contents of a filename relative to the FS root as a string.
-func (d Dir) Read(name string) (string, error) {
+func (d Dir) Read(name string) (_ string, err error) {
 	name = path.Join(d.path, path.Clean(name))
 
+	span := startSpan(d.dot.ctx, "Dir.Read", "file.path", name)
+	defer func() { span.end(err) }()
+
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer bufPool.Put(buf)
@@ -33,6 +33,7 @@ type DotDirConfig struct {
 }
 
 var _ CleanupDotProvider = &DotDirConfig{}
+var _ HandoffDotProvider = &DotDirConfig{}
 
 func (c *DotDirConfig) FieldName() string { return c.Name }
 func (p *DotDirConfig) Init(ctx context.Context) error {
@@ -48,8 +49,16 @@ func (p *DotDirConfig) Init(ctx context.Context) error {
 	p.FS = newfs
 	return nil
 }
+func (p *DotDirConfig) Handoff(old DotConfig) bool {
+	o, ok := old.(*DotDirConfig)
+	if !ok || p.FS != nil || o.FS == nil {
+		return false
+	}
+	p.FS = o.FS
+	return true
+}
 func (p *DotDirConfig) Value(r Request) (any, error) {
-	return Dir{dot: &dotFS{p.FS, GetLogger(r.R.Context()), make(map[fs.File]struct{})}, path: "."}, nil
+	return Dir{dot: &dotFS{p.FS, GetLogger(r.R.Context()), r.R.Context(), make(map[fs.File]struct{})}, path: "."}, nil
 }
 func (p *DotDirConfig) Cleanup(a any, err error) error {
 	v := a.(Dir).dot
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"net/url"
 	"path"
+	"strings"
 )
 
 type dotXProvider struct {
@@ -15,7 +17,9 @@ type dotXProvider struct {
 
 func (dotXProvider) FieldName() string            { return "X" }
 func (dotXProvider) Init(_ context.Context) error { return nil }
-func (p dotXProvider) Value(Request) (any, error) { return DotX(p), nil }
+func (p dotXProvider) Value(r Request) (any, error) {
+	return DotX{p.instance, r.R.Context()}, nil
+}
 
 func (dotXProvider) Cleanup(_ any, err error) error {
 	if errors.As(err, &ReturnError{}) {
@@ -29,6 +33,7 @@ var _ CleanupDotProvider = dotXProvider{}
 // DotX is used as the field at .X in all template invocations.
 type DotX struct {
 	instance *Instance
+	ctx      context.Context
 }
 
 // StaticFileHash returns the sha-384 hash of the named asset file to be used
@@ -42,9 +47,23 @@ func (d DotX) StaticFileHash(urlpath string) (string, error) {
 	return fileinfo.hash, nil
 }
 
+// InitOutput returns the rendered output of the INIT template with the given
+// name, without the `INIT` keyword and order, like `migrate` for a template
+// named `INIT 10 migrate`. Leading and trailing whitespace is trimmed.
+func (d DotX) InitOutput(name string) (string, error) {
+	output, ok := d.instance.initOutputs[name]
+	if !ok {
+		return "", fmt.Errorf("initializer does not exist: '%s'", name)
+	}
+	return output, nil
+}
+
 // Template invokes the template name with the given dot value, returning the
 // result as a html string.
-func (c DotX) Template(name string, dot any) (template.HTML, error) {
+func (c DotX) Template(name string, dot any) (_ template.HTML, err error) {
+	span := startSpan(c.ctx, "X.Template", "xtemplate.template", name)
+	defer func() { span.end(err) }()
+
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer bufPool.Put(buf)
@@ -59,6 +78,96 @@ func (c DotX) Template(name string, dot any) (template.HTML, error) {
 	return template.HTML(buf.String()), nil
 }
 
+// TextTemplate invokes the text template name with the given dot value,
+// returning the result as a string so it is escaped like any other value when
+// it's inserted into an html template. See Config.TextTemplateExtension.
+func (c DotX) TextTemplate(name string, dot any) (_ string, err error) {
+	span := startSpan(c.ctx, "X.TextTemplate", "xtemplate.template", name)
+	defer func() { span.end(err) }()
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+
+	t := c.instance.textTemplates.Lookup(name)
+	if t == nil {
+		return "", fmt.Errorf("failed to lookup text template name: '%s'", name)
+	}
+	if err := t.Execute(buf, dot); err != nil {
+		return "", fmt.Errorf("failed to execute text template '%s': %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// URL builds the url of the route named by route, which is either its pattern
+// like `GET /contact/{id}` or the name of the template that handles it like
+// `SSE /events` or `/contact.html`. Path wildcards are filled from args, which
+// are alternating names and values, and each value is escaped. A `{name...}`
+// wildcard keeps the slashes in its value. Arguments that don't name a
+// wildcard are added as query parameters. It fails if the route doesn't exist
+// or a wildcard has no value. Routes with a host pattern return a
+// scheme-relative url like `//api.example.com/x`.
+func (c DotX) URL(route string, args ...any) (string, error) {
+	hostpath, ok := c.instance.routeURLs[strings.Join(strings.Fields(route), " ")]
+	if !ok {
+		return "", fmt.Errorf("route does not exist: '%s'", route)
+	}
+	if len(args)%2 != 0 {
+		return "", fmt.Errorf("failed to build url for route '%s': expected pairs of names and values, got %d arguments", route, len(args))
+	}
+	values := map[string]string{}
+	var order []string
+	for i := 0; i < len(args); i += 2 {
+		name := fmt.Sprint(args[i])
+		if _, ok := values[name]; !ok {
+			order = append(order, name)
+		}
+		values[name] = fmt.Sprint(args[i+1])
+	}
+
+	host, path_, _ := strings.Cut(hostpath, "/")
+	segments := strings.Split(path_, "/")
+	for i, seg := range segments {
+		if seg == "{$}" {
+			segments[i] = ""
+			continue
+		}
+		if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+			continue
+		}
+		name, rest := strings.CutSuffix(seg[1:len(seg)-1], "...")
+		value, ok := values[name]
+		if !ok {
+			return "", fmt.Errorf("failed to build url for route '%s': missing value for path wildcard '%s'", route, name)
+		}
+		delete(values, name)
+		if rest {
+			parts := strings.Split(value, "/")
+			for j, part := range parts {
+				parts[j] = url.PathEscape(part)
+			}
+			segments[i] = strings.Join(parts, "/")
+		} else {
+			segments[i] = url.PathEscape(value)
+		}
+	}
+
+	u := "/" + strings.Join(segments, "/")
+	if host != "" {
+		u = "//" + host + u
+	}
+	if len(values) > 0 {
+		query := url.Values{}
+		for _, name := range order {
+			if value, ok := values[name]; ok {
+				query.Set(name, value)
+			}
+		}
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
+
 // Func returns a function by name to call manually. Can be used in combination
 // with the call and try funcs.
 func (c DotX) Func(name string) any {
@@ -0,0 +1,96 @@
+package xtemplate
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestURL(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"contact.html": {Data: []byte(`
+{{- define "GET /contact/{id}"}}{{end}}
+{{- define "POST /contact/{id}/notes/{note}"}}{{end}}
+{{- define "GET /files/{path...}"}}{{end}}
+{{- define "GET /exact/{$}"}}{{end}}
+{{- define "GET api.example.com/users/{id}"}}{{end}}
+{{- define "SSE /events"}}{{end}}`)},
+		"blog/index.html": {Data: []byte(``)},
+	}
+	instance, _, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+	x := DotX{instance, context.Background()}
+
+	tests := []struct {
+		route string
+		args  []any
+		want  string
+		error string
+	}{
+		{"GET /contact/{id}", []any{"id", 5}, "/contact/5", ""},
+		{"GET  /contact/{id}", []any{"id", "a b/c"}, "/contact/a%20b%2Fc", ""},
+		{"GET /contact/{id}", []any{"id", 1, "tab", "notes", "q", "x&y"}, "/contact/1?q=x%26y&tab=notes", ""},
+		{"GET /contact/{id}", []any{"id", 1, "id", 2}, "/contact/2", ""},
+		{"POST /contact/{id}/notes/{note}", []any{"note", 2, "id", 1}, "/contact/1/notes/2", ""},
+		{"GET /files/{path...}", []any{"path", "a/b c/d"}, "/files/a/b%20c/d", ""},
+		{"GET /exact/{$}", nil, "/exact/", ""},
+		{"GET api.example.com/users/{id}", []any{"id", 7}, "//api.example.com/users/7", ""},
+		{"SSE /events", nil, "/events", ""},
+		{"/contact.html", nil, "/contact", ""},
+		{"/blog/index.html", nil, "/blog", ""},
+		{"GET /missing", nil, "", "route does not exist"},
+		{"GET /contact/{id}", nil, "", "missing value for path wildcard 'id'"},
+		{"GET /contact/{id}", []any{"id"}, "", "expected pairs of names and values"},
+	}
+	for _, test := range tests {
+		got, err := x.URL(test.route, test.args...)
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("URL(%q, %v): expected error containing %q, got %q, %v", test.route, test.args, test.error, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("URL(%q, %v): %v", test.route, test.args, err)
+		} else if got != test.want {
+			t.Errorf("URL(%q, %v): expected %q, got %q", test.route, test.args, test.want, got)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"page.html": {Data: []byte(`
+{{- define "bold"}}<b>{{.}}</b>{{end}}
+{{- define "GET /both"}}{{.X.Template "bold" "<x>"}} {{.X.TextTemplate "line" "<x>"}}{{end}}
+{{- define "GET /missing"}}{{.X.Template "line" "x"}}{{end}}`)},
+		"rows.csv.tmpl": {Data: []byte(`{{define "line"}}<i>{{.}}</i>{{end}}`)},
+	}
+	instance, _, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	w := httptest.NewRecorder()
+	instance.ServeHTTP(w, httptest.NewRequest("GET", "/both", nil))
+	if want := "<b>&lt;x&gt;</b> &lt;i&gt;&lt;x&gt;&lt;/i&gt;"; w.Body.String() != want {
+		t.Errorf("expected html template output as html and text template output escaped %q, got %q", want, w.Body)
+	}
+
+	// html templates don't see the text template namespace
+	w = httptest.NewRecorder()
+	instance.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != 500 {
+		t.Errorf("expected status 500 for a text template called with .X.Template, got %d", w.Code)
+	}
+}
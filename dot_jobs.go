@@ -0,0 +1,221 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DotJobs is used as the dot field of a [DotJobsConfig] to add jobs to the
+// queue from any template. Adding a job is fast, so handlers can respond
+// immediately and leave slow work like sending emails to a `JOB` template that
+// runs in the background:
+//
+//	{{define "POST /signup"}}
+//	{{.Jobs.Enqueue "send-welcome" (dict "email" (.Req.FormValue "email"))}}
+//	{{end}}
+//
+//	{{define "JOB send-welcome"}}
+//	{{.Mail.Send .Job.Payload.email "Welcome!"}}
+//	{{end}}
+type DotJobs struct {
+	config *DotJobsConfig
+	ctx    context.Context
+	// db is the value of the database field that stores jobs in the same
+	// template execution, see bind.
+	db *DotDB
+}
+
+func (d *DotJobs) bind(field func(name string) any) {
+	if d.config.Database != "" {
+		d.db, _ = field(d.config.Database).(*DotDB)
+	}
+}
+
+var _ dotBinder = &DotJobs{}
+
+// Enqueue adds a job that executes the template named `JOB <name>` with the
+// payload as `.Job.Payload`. The payload must be encodable as json, like a
+// string, number, list, or dict. It returns the id of the job. Jobs stored in
+// a database are added in the transaction of the template's database field,
+// so they are only stored if the template succeeds.
+func (d *DotJobs) Enqueue(name string, payload any) (string, error) {
+	if d.config.instance == nil || d.config.instance.jobTemplates[name] == nil {
+		return "", fmt.Errorf("no job template named 'JOB %s'", name)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode job payload: %w", err)
+	}
+	job := &queuedJob{ID: uuid.NewString(), Name: name, Payload: data}
+	span := startSpan(d.ctx, "Jobs.Enqueue", "xtemplate.job.name", name, "xtemplate.job.id", job.ID)
+	if q, ok := d.config.queue.(*sqlJobQueue); ok && d.db != nil {
+		if err = d.db.makeTx(); err == nil {
+			err = q.insert(d.ctx, d.db.tx, job)
+		}
+	} else {
+		err = d.config.queue.enqueue(d.ctx, job)
+	}
+	span.end(err)
+	if err != nil {
+		return "", fmt.Errorf("failed to enqueue job '%s': %w", name, err)
+	}
+	GetLogger(d.ctx).Debug("enqueued job", slog.String("job_name", name), slog.String("job_id", job.ID))
+	return job.ID, nil
+}
+
+// DotJob is used as the .Job field in `JOB <name>` templates and describes
+// the job being run. A job succeeds if its template executes without error or
+// calls `return`. If it fails, it is retried with exponential backoff until
+// it has been attempted DotJobsConfig.MaxAttempts times, and then it's moved
+// to the dead letters of the queue.
+type DotJob struct {
+	ID   string
+	Name string
+	// Payload is the value passed to Enqueue decoded from json.
+	Payload any
+	// Attempt is 1 on the first run of the job and increases with every retry.
+	Attempt int
+}
+
+type dotJobProvider struct{}
+
+func (dotJobProvider) FieldName() string            { return "Job" }
+func (dotJobProvider) Init(_ context.Context) error { return nil }
+func (dotJobProvider) Value(r Request) (any, error) {
+	job, _ := r.R.Context().Value(jobKey).(*DotJob)
+	return job, nil
+}
+
+var _ DotConfig = dotJobProvider{}
+
+type jobType struct{}
+
+var jobKey = jobType{}
+
+// jobNameRegex matches valid job names, which are used in nats subjects.
+var jobNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// maxJobBackoff limits the delay before retrying a failed job.
+const maxJobBackoff = time.Hour
+
+// startJobWorkers starts the workers of every jobs queue, which run until ctx
+// is cancelled.
+func (x *Instance) startJobWorkers(ctx context.Context) {
+	for _, jobs := range x.jobQueues {
+		x.config.Logger.Debug("starting job workers", slog.String("field", jobs.Name), slog.Int("concurrency", jobs.Concurrency))
+		for range jobs.Concurrency {
+			x.background.Add(1)
+			go x.runJobWorker(ctx, jobs)
+		}
+	}
+}
+
+// runJobWorker claims and runs jobs from the queue one at a time.
+func (x *Instance) runJobWorker(ctx context.Context, jobs *DotJobsConfig) {
+	defer x.background.Done()
+	log := x.config.Logger.With(slog.String("jobs", jobs.Name))
+	for ctx.Err() == nil {
+		// hold a read lock like a request so the instance waits for the job
+		// to finish before shutting down its providers, see drain
+		if !x.inflight.TryRLock() {
+			return
+		}
+		job, err := jobs.queue.claim(ctx)
+		if job != nil {
+			x.runJob(ctx, jobs, job, log)
+		}
+		x.inflight.RUnlock()
+		if err != nil && ctx.Err() == nil {
+			log.Warn("failed to claim job", slog.Any("error", err))
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(jobs.pollInterval):
+			}
+		}
+	}
+}
+
+// runJob executes the template of job and records the outcome in the queue.
+// The job is not cancelled when ctx is, so that it can finish while the
+// instance is drained.
+func (x *Instance) runJob(ctx context.Context, jobs *DotJobsConfig, job *queuedJob, log *slog.Logger) {
+	start := time.Now()
+	log = log.With(slog.String("job_name", job.Name), slog.String("job_id", job.ID), slog.Int("attempt", job.Attempt))
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobs.timeout)
+	defer cancel()
+
+	err := x.executeJob(ctx, job, log)
+	if err == nil {
+		log.Info("executed job", slog.Duration("duration", time.Since(start)))
+		if err := jobs.queue.complete(ctx, job); err != nil {
+			log.Error("failed to mark job as completed", slog.Any("error", err))
+		}
+		return
+	}
+	x.config.Metrics.templateError("JOB " + job.Name)
+
+	if job.Attempt >= jobs.MaxAttempts {
+		log.Error("job failed on its last attempt, moving it to dead letters", slog.Duration("duration", time.Since(start)), slog.Any("error", err))
+		if err := jobs.queue.dead(ctx, job, err); err != nil {
+			log.Error("failed to move job to dead letters", slog.Any("error", err))
+		}
+		return
+	}
+	delay := min(jobs.backoff<<(job.Attempt-1), maxJobBackoff)
+	if delay <= 0 {
+		delay = maxJobBackoff
+	}
+	log.Warn("job failed, retrying", slog.Duration("duration", time.Since(start)), slog.Duration("retry_in", delay), slog.Any("error", err))
+	if err := jobs.queue.retry(ctx, job, delay, err); err != nil {
+		log.Error("failed to schedule job retry", slog.Any("error", err))
+	}
+}
+
+// executeJob executes the `JOB <name>` template with the job in the dot.
+func (x *Instance) executeJob(ctx context.Context, job *queuedJob, log *slog.Logger) error {
+	tmpl := x.jobTemplates[job.Name]
+	if tmpl == nil {
+		return fmt.Errorf("no job template named 'JOB %s'", job.Name)
+	}
+	dj := &DotJob{ID: job.ID, Name: job.Name, Attempt: job.Attempt}
+	if err := json.Unmarshal(job.Payload, &dj.Payload); err != nil {
+		return fmt.Errorf("failed to decode job payload: %w", err)
+	}
+	ctx = context.WithValue(ctx, loggerKey, log)
+	ctx = context.WithValue(ctx, jobKey, dj)
+	ctx, span := x.config.Tracer.startTrace(ctx, "JOB "+job.Name, nil)
+	w, r := httptest.NewRecorder(), httptest.NewRequest("", "/", nil).WithContext(ctx)
+
+	dot, err := x.jobDot.value(ctx, w, r)
+	if err == nil {
+		err = tmpl.Execute(new(bytes.Buffer), *dot)
+		if errors.As(err, &ReturnError{}) {
+			err = nil
+		}
+		err = x.jobDot.cleanup(dot, err)
+	}
+	span.end(err)
+	return err
+}
+
+// jobTemplateName returns the name of the job defined by a template named like
+// `JOB <name>`.
+func jobTemplateName(name string) (string, error) {
+	job := strings.TrimPrefix(name, "JOB ")
+	if !jobNameRegex.MatchString(job) {
+		return "", fmt.Errorf("invalid job name '%s', expected only letters, digits, '_', and '-'", job)
+	}
+	return job, nil
+}
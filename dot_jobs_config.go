@@ -0,0 +1,143 @@
+package xtemplate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// WithJobs adds a jobs dot field named name that persists jobs in the
+// database dot field named database. See [DotJobsConfig] for the defaults of
+// the other options.
+func WithJobs(name, database string) Option {
+	return func(c *Config) error {
+		c.Jobs = append(c.Jobs, DotJobsConfig{Name: name, Database: database})
+		return nil
+	}
+}
+
+// DotJobsConfig configures a durable job queue. Templates enqueue jobs with
+// [DotJobs.Enqueue], and the instance runs them in the background by executing
+// the template named like `JOB <name>`, see [DotJob].
+//
+// Jobs are persisted either in a table of the SQL database configured as the
+// dot field named by Database, or in a JetStream stream of the nats dot field
+// named by Nats. Exactly one must be set. Jobs that are enqueued while an
+// instance is reloaded or the process restarts are run by the next instance.
+type DotJobsConfig struct {
+	Name string `json:"name"`
+	// Database is the field name of the database that stores jobs. Jobs are
+	// added in the same transaction as the other queries of the template
+	// that enqueues them, so they commit or roll back together.
+	Database string `json:"database,omitempty"`
+	// Nats is the field name of the nats connection whose JetStream stores
+	// jobs.
+	Nats string `json:"nats,omitempty"`
+	// Table is the name of the table that stores jobs in Database, which is
+	// created if it doesn't exist. Default `xtemplate_jobs`.
+	Table string `json:"table,omitempty"`
+	// Stream is the name of the JetStream stream that stores jobs in Nats,
+	// which is created if it doesn't exist. Jobs are published to the
+	// subject `<stream>.pending.<name>`, and jobs that failed every attempt
+	// are moved to `<stream>.dead.<name>`. Default `XTEMPLATE_JOBS`.
+	Stream string `json:"stream,omitempty"`
+	// Concurrency is the maximum number of jobs that run at the same time.
+	// Default 4.
+	Concurrency int `json:"concurrency,omitempty"`
+	// MaxAttempts is the number of times a job is run before it's moved to
+	// the dead letters. Default 5.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// Backoff is the delay before the first retry of a failed job, which
+	// doubles with every attempt up to an hour. Default `10s`.
+	Backoff string `json:"backoff,omitempty"`
+	// Timeout is the maximum time a job runs. A job whose worker stopped
+	// without finishing it, e.g. because the process crashed, is retried
+	// after this time. Default `5m`.
+	Timeout string `json:"timeout,omitempty"`
+	// PollInterval is how often the database is checked for jobs that are
+	// due. Default `1s`.
+	PollInterval string `json:"poll_interval,omitempty"`
+
+	queue        jobQueue
+	instance     *Instance
+	lookup       func(name string) DotConfig
+	backoff      time.Duration
+	timeout      time.Duration
+	pollInterval time.Duration
+}
+
+var _ DotConfig = &DotJobsConfig{}
+
+var sqlIdentifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (d *DotJobsConfig) FieldName() string { return d.Name }
+func (d *DotJobsConfig) Init(ctx context.Context) error {
+	if d.Concurrency <= 0 {
+		d.Concurrency = 4
+	}
+	if d.MaxAttempts <= 0 {
+		d.MaxAttempts = 5
+	}
+	for _, opt := range []struct {
+		value *string
+		dur   *time.Duration
+		def   string
+	}{{&d.Backoff, &d.backoff, "10s"}, {&d.Timeout, &d.timeout, "5m"}, {&d.PollInterval, &d.pollInterval, "1s"}} {
+		if *opt.value == "" {
+			*opt.value = opt.def
+		}
+		var err error
+		if *opt.dur, err = time.ParseDuration(*opt.value); err != nil || *opt.dur <= 0 {
+			return fmt.Errorf("invalid duration '%s' in jobs config '%s'", *opt.value, d.Name)
+		}
+	}
+
+	switch {
+	case d.Database != "" && d.Nats != "":
+		return fmt.Errorf("jobs '%s' must be stored in either a database or nats, not both", d.Name)
+	case d.Database != "":
+		if d.Table == "" {
+			d.Table = "xtemplate_jobs"
+		}
+		if !sqlIdentifierRegex.MatchString(d.Table) {
+			return fmt.Errorf("invalid jobs table name '%s'", d.Table)
+		}
+		db, ok := d.lookupProvider(d.Database).(*DotDBConfig)
+		if !ok || db.DB == nil {
+			return fmt.Errorf("jobs '%s' are stored in database '%s' which is not configured", d.Name, d.Database)
+		}
+		q := &sqlJobQueue{db: db.DB, table: d.Table, dialect: db.dialect(), timeout: d.timeout}
+		if err := q.init(ctx); err != nil {
+			return fmt.Errorf("failed to create jobs table: %w", err)
+		}
+		d.queue = q
+	case d.Nats != "":
+		if d.Stream == "" {
+			d.Stream = "XTEMPLATE_JOBS"
+		}
+		n, ok := d.lookupProvider(d.Nats).(*DotNatsConfig)
+		if !ok || n.js == nil {
+			return fmt.Errorf("jobs '%s' are stored in nats '%s' which is not configured", d.Name, d.Nats)
+		}
+		q := &jetStreamJobQueue{js: n.js, stream: d.Stream, timeout: d.timeout, pollInterval: d.pollInterval}
+		if err := q.init(ctx, d.Concurrency); err != nil {
+			return fmt.Errorf("failed to create jobs stream: %w", err)
+		}
+		d.queue = q
+	default:
+		return fmt.Errorf("jobs '%s' must be stored in a database or nats", d.Name)
+	}
+	return nil
+}
+
+func (d *DotJobsConfig) lookupProvider(name string) DotConfig {
+	if d.lookup == nil {
+		return nil
+	}
+	return d.lookup(name)
+}
+
+func (d *DotJobsConfig) Value(r Request) (any, error) {
+	return &DotJobs{config: d, ctx: r.R.Context()}, nil
+}
@@ -0,0 +1,126 @@
+package xtemplate
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestJobs(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"jobs.html": {Data: []byte(`
+{{- define "INIT schema"}}{{.DB.Exec "CREATE TABLE done (name TEXT, attempt INTEGER)"}}{{end}}
+{{- define "POST /signup"}}{{.Jobs.Enqueue "welcome" (dict "email" "ann@example.com")}}{{end}}
+{{- define "POST /flaky"}}{{.Jobs.Enqueue "flaky" 1}}{{end}}
+{{- define "POST /broken"}}{{.Jobs.Enqueue "broken" nil}}{{end}}
+{{- define "POST /unknown"}}{{.Jobs.Enqueue "unknown" nil}}{{end}}
+{{- define "JOB welcome"}}{{.DB.Exec "INSERT INTO done VALUES (?, ?)" .Job.Payload.email .Job.Attempt}}{{end}}
+{{- define "JOB flaky"}}{{if lt .Job.Attempt 2}}{{.DB.QueryVal "SELECT missing FROM nowhere"}}{{end}}{{.DB.Exec "INSERT INTO done VALUES ('flaky', ?)" .Job.Attempt}}{{end}}
+{{- define "JOB broken"}}{{.DB.QueryVal "SELECT missing FROM nowhere"}}{{end}}`)},
+	}
+	config.Databases = []DotDBConfig{{Name: "DB", Driver: "sqlite3", Connstr: "file:jobs_test?mode=memory&cache=shared", MaxOpenConns: 1}}
+	config.Jobs = []DotJobsConfig{{Name: "Jobs", Database: "DB", Concurrency: 1, MaxAttempts: 2, Backoff: "10ms", PollInterval: "10ms"}}
+
+	instance, stats, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+	if stats.JobTemplates != 3 {
+		t.Errorf("expected 3 job templates, got %d", stats.JobTemplates)
+	}
+
+	for path, status := range map[string]int{"/signup": 200, "/flaky": 200, "/broken": 200, "/unknown": 500} {
+		w := httptest.NewRecorder()
+		instance.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != status {
+			t.Errorf("POST %s: expected status %d, got %d: %s", path, status, w.Code, w.Body)
+		}
+	}
+
+	db := instance.jobQueues[0].queue.(*sqlJobQueue).db
+	query := func(q string) string {
+		var rows []string
+		r, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		for r.Next() {
+			var a, b string
+			r.Scan(&a, &b)
+			rows = append(rows, a+":"+b)
+		}
+		return strings.Join(rows, ",")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && query("SELECT name, status FROM xtemplate_jobs") != "broken:dead" {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := query("SELECT name, status FROM xtemplate_jobs"); got != "broken:dead" {
+		t.Errorf("expected only the broken job to remain as dead, got %q", got)
+	}
+	if got := query("SELECT name, attempt FROM done ORDER BY name"); got != "ann@example.com:1,flaky:2" {
+		t.Errorf("expected the welcome job to run once and the flaky job to succeed on its second attempt, got %q", got)
+	}
+}
+
+func TestRebindPlaceholders(t *testing.T) {
+	query := "UPDATE t SET a = ? WHERE id = ? AND b = ?"
+	if got := rebindPlaceholders("sqlite", query); got != query {
+		t.Errorf("expected sqlite query to be unchanged, got %s", got)
+	}
+	if got, want := rebindPlaceholders("postgres", query), "UPDATE t SET a = $1 WHERE id = $2 AND b = $3"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestEnqueueInTransaction(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"signup.html": {Data: []byte(`
+{{- define "INIT schema"}}{{.DB.Exec "CREATE TABLE users (email TEXT)"}}{{end}}
+{{- define "POST /signup"}}{{.DB.Exec "INSERT INTO users VALUES (?)" "ann@example.com"}}{{.Jobs.Enqueue "welcome" "ann@example.com"}}{{end}}
+{{- define "POST /fail"}}{{.DB.Exec "INSERT INTO users VALUES (?)" "bob@example.com"}}{{.Jobs.Enqueue "welcome" "bob@example.com"}}{{.DB.QueryVal "SELECT missing FROM nowhere"}}{{end}}
+{{- define "JOB welcome"}}{{end}}`)},
+	}
+	config.Databases = []DotDBConfig{{Name: "DB", Driver: "sqlite3", Connstr: "file:" + filepath.Join(t.TempDir(), "jobs.db")}}
+	config.Jobs = []DotJobsConfig{{Name: "Jobs", Database: "DB"}}
+
+	instance, _, _, err := config.Instance(WithoutBackground())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	for path, status := range map[string]int{"/signup": 200, "/fail": 500} {
+		w := httptest.NewRecorder()
+		instance.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != status {
+			t.Errorf("POST %s: expected status %d, got %d: %s", path, status, w.Code, w.Body)
+		}
+	}
+
+	db := instance.jobQueues[0].queue.(*sqlJobQueue).db
+	var users, jobs int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM xtemplate_jobs WHERE payload = '\"ann@example.com\"'").Scan(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	if users != 1 || jobs != 1 {
+		t.Errorf("expected the job to be stored only with the committed user, got %d users and %d jobs", users, jobs)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM xtemplate_jobs").Scan(&jobs); err != nil || jobs != 1 {
+		t.Errorf("expected the job of the failed request to be rolled back, got %d jobs: %v", jobs, err)
+	}
+}
@@ -46,5 +46,13 @@ func (d *DotNats) Request(subject, data string, timeout_ ...time.Duration) (*nat
 		return nil, fmt.Errorf("too many timeout args")
 	}
 
-	return d.Conn.Request(subject, []byte(data), timeout)
+	span := startSpan(d.ctx, "Nats.Request", "messaging.destination.name", subject)
+	msg := nats.NewMsg(subject)
+	msg.Data = []byte(data)
+	if tp := currentTraceparent(d.ctx); tp != "" {
+		msg.Header.Set("traceparent", tp)
+	}
+	res, err := d.Conn.RequestMsg(msg, timeout)
+	span.end(err)
+	return res, err
 }
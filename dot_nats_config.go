@@ -22,17 +22,24 @@ type NatsConfig struct {
 	JetStreamOptions       []jetstream.JetStreamOpt // encode jetstream opts into json?
 }
 
+// DotNatsConfig configures a dot field that connects to nats, optionally
+// starting an in-process nats server. The server and connection are stopped
+// when the [Instance] that owns them is shut down with [Instance.Shutdown],
+// not when Config.Ctx is cancelled.
 type DotNatsConfig struct {
 	Name string `json:"name"`
 
 	*NatsConfig `json:"nats_config"`
-	Conn        *nats.Conn
+	Conn        *nats.Conn `json:"-"`
 
-	server *server.Server
-	js     jetstream.JetStream
+	server    *server.Server
+	js        jetstream.JetStream
+	connected bool
 }
 
 var _ DotConfig = &DotNatsConfig{}
+var _ ShutdownDotProvider = &DotNatsConfig{}
+var _ HandoffDotProvider = &DotNatsConfig{}
 
 func (d *DotNatsConfig) FieldName() string { return d.Name }
 func (d *DotNatsConfig) Init(ctx context.Context) error {
@@ -65,24 +72,43 @@ func (d *DotNatsConfig) Init(ctx context.Context) error {
 		}
 		d.server.Start()
 
-		// shut down the server when the instance is cancelled
-		done := ctx.Done()
-		if done != nil {
-			go func() {
-				<-done
-				d.server.Shutdown()
-			}()
-		}
-
 		nats.InProcessServer(d.server)(&connOpt)
 	}
 	d.Conn, err = connOpt.Connect()
 	if err != nil {
+		if d.server != nil {
+			d.server.Shutdown()
+		}
 		return fmt.Errorf("failed to connect to in-process server: %w", err)
 	}
+	d.connected = true
 	d.js, err = jetstream.New(d.Conn, d.NatsConfig.JetStreamOptions...)
 	return err
 }
 func (d *DotNatsConfig) Value(r Request) (any, error) {
 	return &DotNats{Conn: d.Conn, JetStream: d.js, ctx: r.R.Context()}, nil
 }
+
+// Shutdown closes the connection if it was opened by Init, and shuts down the
+// in-process server if one was started.
+func (d *DotNatsConfig) Shutdown(ctx context.Context) error {
+	if d.connected {
+		d.Conn.Close()
+	}
+	if d.server != nil {
+		d.server.Shutdown()
+	}
+	return nil
+}
+
+// Handoff reuses the connection, JetStream client, and in-process server of the
+// previous instance if it is configured with the same options. This preserves
+// in-memory JetStream state across reloads.
+func (d *DotNatsConfig) Handoff(old DotConfig) bool {
+	o, ok := old.(*DotNatsConfig)
+	if !ok || o.Conn == nil || d.Conn != nil {
+		return false
+	}
+	d.Conn, d.js, d.server, d.connected = o.Conn, o.js, o.server, o.connected
+	return true
+}
@@ -2,6 +2,7 @@ package xtemplate
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 )
 
@@ -29,3 +30,25 @@ var _ DotConfig = dotReqProvider{}
 type DotReq struct {
 	*http.Request
 }
+
+type requestValuesType struct{}
+
+var requestValuesKey = requestValuesType{}
+
+// Value returns the value stored in the request with [DotReq.SetValue], or nil.
+func (d DotReq) Value(key string) any {
+	values, _ := d.Context().Value(requestValuesKey).(map[string]any)
+	return values[key]
+}
+
+// SetValue stores a value in the request that can be read with
+// [DotReq.Value] by every template that handles it, including the templates
+// executed after a `MIDDLEWARE` template. It returns an empty string.
+func (d DotReq) SetValue(key string, value any) (string, error) {
+	values, ok := d.Context().Value(requestValuesKey).(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("request values are not available in this context")
+	}
+	values[key] = value
+	return "", nil
+}
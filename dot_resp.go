@@ -2,7 +2,6 @@ package xtemplate
 
 import (
 	"context"
-	"errors"
 	"io"
 	"log/slog"
 	"maps"
@@ -17,9 +16,14 @@ type dotRespProvider struct{}
 func (dotRespProvider) FieldName() string            { return "Resp" }
 func (dotRespProvider) Init(_ context.Context) error { return nil }
 func (dotRespProvider) Value(r Request) (any, error) {
+	status := http.StatusOK
+	if e, ok := r.R.Context().Value(dotErrorKey).(DotError); ok {
+		// error templates respond with the error status by default
+		status = e.Status
+	}
 	return DotResp{
 		Header: make(http.Header),
-		status: http.StatusOK,
+		status: status,
 		w:      r.W, r: r.R,
 		log: GetLogger(r.R.Context()),
 	}, nil
@@ -27,11 +31,8 @@ func (dotRespProvider) Value(r Request) (any, error) {
 
 func (dotRespProvider) Cleanup(v any, err error) error {
 	d := v.(DotResp)
-	var errSt ErrorStatus
-	if errors.As(err, &errSt) {
-		// headers?
-		d.w.WriteHeader(int(errSt))
-	} else if err == nil {
+	// an ErrorStatus is written by the handler, see Instance.writeError
+	if err == nil {
 		maps.Copy(d.w.Header(), d.Header)
 		d.w.WriteHeader(d.status)
 	}
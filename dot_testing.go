@@ -0,0 +1,345 @@
+package xtemplate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+type dotTestProvider struct {
+	instance *Instance
+}
+
+func (dotTestProvider) FieldName() string            { return "Test" }
+func (dotTestProvider) Init(_ context.Context) error { return nil }
+func (p dotTestProvider) Value(r Request) (any, error) {
+	state, _ := r.R.Context().Value(testStateKey).(*testState)
+	return &DotTest{p.instance, state}, nil
+}
+
+var _ DotConfig = dotTestProvider{}
+
+type testStateType struct{}
+
+var testStateKey = testStateType{}
+
+// testState collects the outcome of a running test.
+type testState struct {
+	failures []string
+	logs     []string
+}
+
+// DotTest is used as the .Test field in test templates, which are templates
+// named like `TEST contact page renders`. Test templates are not routes; they
+// are run by [Config.RunTests] or the `xtemplate test` command against a
+// throwaway instance whose databases are replaced with empty in-memory sqlite
+// databases, so schemas and fixtures should be created by INIT templates. Each
+// test gets a new instance. A test fails if an assertion fails or the
+// template returns an error.
+//
+//	{{define "TEST contact page renders"}}
+//	{{$r := .Test.Get "/contact/1"}}
+//	{{.Test.Equal 200 $r.Status "status"}}
+//	{{.Test.Assert ($r.Find "h1.name").Len "has a name heading"}}
+//	{{end}}
+type DotTest struct {
+	instance *Instance
+	state    *testState
+}
+
+// Get requests urlpath from the instance under test. headers are alternating
+// header names and values.
+func (t *DotTest) Get(urlpath string, headers ...string) (*TestResponse, error) {
+	return t.Request("GET", urlpath, "", headers...)
+}
+
+// Post requests urlpath from the instance under test with the given body and
+// content type, e.g. `application/x-www-form-urlencoded`.
+func (t *DotTest) Post(urlpath, contentType, body string, headers ...string) (*TestResponse, error) {
+	return t.Request("POST", urlpath, body, append([]string{"Content-Type", contentType}, headers...)...)
+}
+
+// Request sends a request with any method to the instance under test, without
+// a network connection. headers are alternating header names and values.
+func (t *DotTest) Request(method, urlpath, body string, headers ...string) (*TestResponse, error) {
+	if len(headers)%2 != 0 {
+		return nil, fmt.Errorf("headers must be pairs of names and values, got %d strings", len(headers))
+	}
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, urlpath, reader)
+	for i := 0; i < len(headers); i += 2 {
+		r.Header.Add(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	t.instance.ServeHTTP(w, r)
+	return &TestResponse{Status: w.Code, Header: w.Header(), Body: w.Body.String()}, nil
+}
+
+// Assert fails the test with message if cond is not true, or is the zero value
+// of its type. The test continues after a failed assertion.
+func (t *DotTest) Assert(cond any, message string) string {
+	if v := reflect.ValueOf(cond); !v.IsValid() || v.IsZero() {
+		t.fail(message)
+	}
+	return ""
+}
+
+// Equal fails the test if expected and actual are not equal, using message to
+// describe the value being compared. Numbers are compared by value regardless
+// of their type.
+func (t *DotTest) Equal(expected, actual any, message string) string {
+	if !testEqual(expected, actual) {
+		t.fail(fmt.Sprintf("%s: expected %#v, got %#v", message, expected, actual))
+	}
+	return ""
+}
+
+// Fail fails the test with message and continues.
+func (t *DotTest) Fail(message string) string {
+	t.fail(message)
+	return ""
+}
+
+// Log adds a message to the output of the test, which is shown when the test
+// fails or in verbose mode.
+func (t *DotTest) Log(message string) string {
+	if t.state != nil {
+		t.state.logs = append(t.state.logs, message)
+	}
+	return ""
+}
+
+func (t *DotTest) fail(message string) {
+	if t.state != nil {
+		t.state.failures = append(t.state.failures, message)
+	}
+}
+
+// testEqual reports whether expected and actual are equal. Numbers are equal
+// if they have the same value regardless of their type. Integers are compared
+// exactly, and compared to floats as float64.
+func testEqual(expected, actual any) bool {
+	e, a := reflect.ValueOf(expected), reflect.ValueOf(actual)
+	if eq, ok := testIntegersEqual(e, a); ok {
+		return eq
+	}
+	ef, eok := testNumber(e)
+	af, aok := testNumber(a)
+	if eok && aok {
+		return ef == af
+	}
+	return reflect.DeepEqual(expected, actual)
+}
+
+// testIntegersEqual compares e and a if they are both signed or unsigned
+// integers.
+func testIntegersEqual(e, a reflect.Value) (eq, ok bool) {
+	switch {
+	case !e.IsValid() || !a.IsValid():
+		return false, false
+	case e.CanInt() && a.CanInt():
+		return e.Int() == a.Int(), true
+	case e.CanUint() && a.CanUint():
+		return e.Uint() == a.Uint(), true
+	case e.CanInt() && a.CanUint():
+		return e.Int() >= 0 && uint64(e.Int()) == a.Uint(), true
+	case e.CanUint() && a.CanInt():
+		return a.Int() >= 0 && uint64(a.Int()) == e.Uint(), true
+	}
+	return false, false
+}
+
+func testNumber(v reflect.Value) (float64, bool) {
+	switch {
+	case !v.IsValid():
+		return 0, false
+	case v.CanInt():
+		return float64(v.Int()), true
+	case v.CanUint():
+		return float64(v.Uint()), true
+	case v.CanFloat():
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// TestResponse is the response to a request made by a test template.
+type TestResponse struct {
+	Status int
+	Header http.Header
+	Body   string
+
+	doc *html.Node
+}
+
+// Contains reports whether the body contains s.
+func (r *TestResponse) Contains(s string) bool {
+	return strings.Contains(r.Body, s)
+}
+
+// Find returns the elements in the html body that match the css selector.
+func (r *TestResponse) Find(selector string) (*TestSelection, error) {
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	if r.doc == nil {
+		if r.doc, err = html.Parse(strings.NewReader(r.Body)); err != nil {
+			return nil, fmt.Errorf("failed to parse response body as html: %w", err)
+		}
+	}
+	return &TestSelection{sel.find(r.doc)}, nil
+}
+
+// TestSelection is a list of html elements found by [TestResponse.Find].
+type TestSelection struct {
+	nodes []*html.Node
+}
+
+// Len returns the number of elements.
+func (s *TestSelection) Len() int {
+	return len(s.nodes)
+}
+
+// Text returns the text content of the first element, with surrounding
+// whitespace removed.
+func (s *TestSelection) Text() string {
+	if len(s.nodes) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(nodeText(s.nodes[0]))
+}
+
+// Texts returns the text content of every element.
+func (s *TestSelection) Texts() []string {
+	texts := make([]string, len(s.nodes))
+	for i, n := range s.nodes {
+		texts[i] = strings.TrimSpace(nodeText(n))
+	}
+	return texts
+}
+
+// Attr returns the value of the named attribute of the first element.
+func (s *TestSelection) Attr(name string) string {
+	if len(s.nodes) == 0 {
+		return ""
+	}
+	return attr(s.nodes[0], strings.ToLower(name))
+}
+
+// TestResult is the outcome of running a test template.
+type TestResult struct {
+	// Name is the name of the test without the `TEST ` prefix.
+	Name string
+	// File is the path of the template file that defines the test.
+	File     string
+	Duration time.Duration
+	// Failures are the messages of failed assertions.
+	Failures []string
+	// Logs are the messages added with .Test.Log.
+	Logs []string
+	// Err is set if the test template or its instance failed to execute.
+	Err error
+}
+
+// Passed reports whether the test had no failures or errors.
+func (r TestResult) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+// withTestDatabases replaces every database with a new, empty, in-memory
+// sqlite database. The sqlite3 driver must be registered by the program.
+func withTestDatabases() Option {
+	return func(c *Config) error {
+		c.Databases = slices.Clone(c.Databases)
+		for i := range c.Databases {
+			d := &c.Databases[i]
+			d.DB = nil
+			d.Driver = "sqlite3"
+			d.Connstr = MemoryDBConnstr()
+		}
+		return nil
+	}
+}
+
+// RunTests runs the test templates whose names match the regular expression
+// run, or all of them if run is empty, and returns their results in order of
+// name. Each test runs against a new instance built from the config where
+// every database is replaced with an empty in-memory sqlite database and
+// scheduled templates and job workers don't run, see [DotTest]. It returns an
+// error if the templates fail to load.
+func (config *Config) RunTests(run string, cfgs ...Option) ([]TestResult, error) {
+	var match *regexp.Regexp
+	if run != "" {
+		var err error
+		if match, err = regexp.Compile(run); err != nil {
+			return nil, fmt.Errorf("invalid test pattern: %w", err)
+		}
+	}
+	cfgs = append(slices.Clone(cfgs), withTestDatabases(), WithoutBackground())
+
+	instance, _, _, err := config.Instance(cfgs...)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, tmpl := range instance.tests {
+		name := strings.TrimPrefix(tmpl.Name(), "TEST ")
+		if match == nil || match.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	instance.Shutdown(instance.config.Ctx)
+
+	var results []TestResult
+	for _, name := range names {
+		instance, _, _, err := config.Instance(cfgs...)
+		if err != nil {
+			results = append(results, TestResult{Name: name, Err: fmt.Errorf("failed to load instance: %w", err)})
+			continue
+		}
+		results = append(results, instance.runTest(instance.templates.Lookup("TEST "+name)))
+		instance.Shutdown(instance.config.Ctx)
+	}
+	return results, nil
+}
+
+// runTest executes the test template tmpl.
+func (instance *Instance) runTest(tmpl *template.Template) TestResult {
+	result := TestResult{Name: strings.TrimPrefix(tmpl.Name(), "TEST ")}
+	if tmpl.Tree != nil {
+		result.File = tmpl.Tree.ParseName
+	}
+	state := &testState{}
+	ctx := context.WithValue(instance.config.Ctx, testStateKey, state)
+	w, r := httptest.NewRecorder(), httptest.NewRequest("", "/", nil).WithContext(ctx)
+
+	start := time.Now()
+	dot, err := instance.testDot.value(instance.config.Ctx, w, r)
+	if err == nil {
+		err = tmpl.Execute(io.Discard, *dot)
+		if errors.As(err, &ReturnError{}) {
+			err = nil
+		}
+		err = instance.testDot.cleanup(dot, err)
+	}
+	result.Duration = time.Since(start)
+	result.Err = err
+	result.Failures = state.failures
+	result.Logs = state.logs
+	return result
+}
@@ -0,0 +1,119 @@
+package xtemplate
+
+import (
+	"database/sql"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTestEqual(t *testing.T) {
+	type named int
+	tests := []struct {
+		expected, actual any
+		want             bool
+	}{
+		{1, 1, true},
+		{1, int64(1), true},
+		{int8(-3), int64(-3), true},
+		{1, uint(1), true},
+		{uint8(200), int32(200), true},
+		{1, 1.0, true},
+		{float32(0.5), 0.5, true},
+		{uint64(2), 2.0, true},
+		{named(4), 4, true},
+		{1, 2, false},
+		{-1, uint64(math.MaxUint64), false},
+		{uint64(math.MaxUint64), -1, false},
+		{1, 1.5, false},
+		// integers are compared exactly even where float64 can't represent them
+		{int64(1<<53 + 1), int64(1 << 53), false},
+		{int64(1<<53 + 1), uint64(1<<53 + 1), true},
+		{uint64(math.MaxUint64), uint64(math.MaxUint64 - 1), false},
+		{"1", 1, false},
+		{"a", "a", true},
+		{[]string{"a"}, []string{"a"}, true},
+		{nil, nil, true},
+		{nil, 0, false},
+		{0, nil, false},
+		{true, 1, false},
+	}
+	for _, test := range tests {
+		if got := testEqual(test.expected, test.actual); got != test.want {
+			t.Errorf("testEqual(%#v, %#v): expected %t, got %t", test.expected, test.actual, test.want, got)
+		}
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	// the configured database already has a table that tests must not see
+	connstr := "file:" + filepath.Join(t.TempDir(), "site.db")
+	db, err := sql.Open("sqlite3", connstr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE production (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	config := New()
+	config.Minify = false
+	config.Databases = []DotDBConfig{{Name: "DB", Driver: "sqlite3", Connstr: connstr}}
+	config.TemplatesFS = fstest.MapFS{
+		".items.html": {Data: []byte(`
+{{- define "INIT schema"}}{{.DB.Exec "CREATE TABLE items (name TEXT)"}}{{end}}
+{{- define "GET /items"}}{{.DB.QueryVal "SELECT COUNT(*) FROM items"}} items{{end}}
+{{- define "TEST passes"}}
+{{- $r := .Test.Get "/items"}}
+{{- .Test.Equal 200 $r.Status "status"}}
+{{- .Test.Assert ($r.Contains "0 items") "lists no items"}}
+{{- .Test.Equal 0 (.DB.QueryVal "SELECT COUNT(*) FROM sqlite_master WHERE name = 'production'") "production tables"}}
+{{- .Test.Log "listed items"}}
+{{- end}}
+{{- define "TEST fails"}}
+{{- $r := .Test.Get "/missing"}}
+{{- .Test.Equal 200 $r.Status "status"}}
+{{- .Test.Assert ($r.Contains "items") "lists items"}}
+{{- .Test.Log "after failures"}}
+{{- end}}
+{{- define "TEST errors"}}{{failf "boom"}}{{end}}`)},
+	}
+
+	results, err := config.RunTests("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range results {
+		names = append(names, r.Name)
+	}
+	if want := []string{"errors", "fails", "passes"}; !slices.Equal(names, want) {
+		t.Fatalf("expected tests %v in order, got %v", want, names)
+	}
+
+	errs, fails, passes := results[0], results[1], results[2]
+	if errs.Passed() || errs.Err == nil || !strings.Contains(errs.Err.Error(), "boom") {
+		t.Errorf("expected the template error to fail the test, got %+v", errs)
+	}
+	if want := []string{"status: expected 200, got 404", "lists items"}; fails.Passed() || !slices.Equal(fails.Failures, want) || !slices.Equal(fails.Logs, []string{"after failures"}) {
+		t.Errorf("expected failures %q and logs, got %+v", want, fails)
+	}
+	if !passes.Passed() || !slices.Equal(passes.Logs, []string{"listed items"}) || passes.File != "/.items.html" {
+		t.Errorf("expected the test to pass against an in-memory database, got %+v", passes)
+	}
+
+	if results, err := config.RunTests("^pass"); err != nil || len(results) != 1 || results[0].Name != "passes" {
+		t.Errorf("expected only the matching test to run, got %+v, %v", results, err)
+	}
+
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'items'").Scan(&tables); err != nil || tables != 0 {
+		t.Errorf("expected tests not to touch the configured database, got %d tables: %v", tables, err)
+	}
+}
@@ -1,8 +1,8 @@
 module github.com/infogulch/xtemplate
 
-go 1.22.3
-
-toolchain go1.23.0
+// go 1.23 is required for http.Request.Pattern, which labels metrics, spans,
+// and access log entries with the route that handled the request.
+go 1.23.0
 
 require (
 	github.com/BurntSushi/toml v1.4.0
@@ -22,6 +22,7 @@ require (
 	github.com/tdewolff/minify/v2 v2.21.2
 	github.com/yuin/goldmark v1.7.8
 	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
+	golang.org/x/net v0.34.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -47,7 +48,6 @@ require (
 	github.com/stretchr/testify v1.10.0 // indirect
 	github.com/tdewolff/parse/v2 v2.7.19 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
-	golang.org/x/net v0.34.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	golang.org/x/time v0.9.0 // indirect
@@ -4,45 +4,63 @@ package xtemplate
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"math"
 	"net/http"
+	"net/http/httptest"
 	"path"
 	"strconv"
 	"strings"
 	"sync"
 )
 
+// executor is a template from either the html/template or the text/template
+// namespace of an instance.
+type executor interface {
+	Execute(w io.Writer, data any) error
+	Name() string
+}
+
 var bufPool = sync.Pool{
 	New: func() any {
 		return new(bytes.Buffer)
 	},
 }
 
-func bufferingTemplateHandler(server *Instance, tmpl *template.Template) http.HandlerFunc {
+// bufferingTemplateHandler executes tmpl into a buffer and writes it to the
+// response if it succeeds. If fragments is not empty the request can select a
+// single named template from it to render instead, see fragment.
+func bufferingTemplateHandler(server *Instance, tmpl *template.Template, fragments map[string]*template.Template) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := GetLogger(r.Context())
 
-		dot, err := server.bufferDot.value(server.config.Ctx, w, r)
-		if err != nil {
-			log.Error("failed to initialize dot value", slog.Any("error", err))
-			http.Error(w, "internal server error", http.StatusInternalServerError)
-			return
+		tmpl := tmpl
+		if len(fragments) > 0 {
+			w.Header().Add("Vary", "HX-Request, HX-Target")
+			name, f, ok := fragment(r, fragments)
+			if !ok {
+				log.Debug("requested fragment not found", slog.String("fragment", name))
+				server.writeError(w, r, http.StatusNotFound, nil, "404 page not found")
+				return
+			}
+			if f != nil {
+				log.Debug("rendering fragment", slog.String("fragment", name))
+				tmpl = f
+			}
 		}
 
 		buf := bufPool.Get().(*bytes.Buffer)
 		buf.Reset()
 		defer bufPool.Put(buf)
 
-		err = tmpl.Execute(buf, *dot)
-
-		if err = server.bufferDot.cleanup(dot, err); err != nil {
-			log.Warn("error executing template", slog.Any("error", err))
-			http.Error(w, "internal server error", http.StatusInternalServerError)
+		if _, ok := server.executeBuffered(w, r, tmpl, buf); !ok {
 			return
 		}
 
@@ -50,12 +68,104 @@ func bufferingTemplateHandler(server *Instance, tmpl *template.Template) http.Ha
 	}
 }
 
-func flushingTemplateHandler(server *Instance, tmpl *template.Template) http.HandlerFunc {
+// executeBuffered executes tmpl into buf with a buffered dot value that writes
+// its response headers to w. If it fails, the error response is written to w
+// and ok is false. returned reports whether the template exited early by
+// calling `return` or a method like `.Resp.ReturnStatus`.
+func (server *Instance) executeBuffered(w http.ResponseWriter, r *http.Request, tmpl executor, buf *bytes.Buffer) (returned, ok bool) {
+	log := GetLogger(r.Context())
+
+	dot, err := server.bufferDot.value(server.config.Ctx, w, r)
+	if err != nil {
+		log.Error("failed to initialize dot value", slog.Any("error", err))
+		if server.config.Dev {
+			writeDevError(w, newDevError("Failed to initialize dot value", err, nil, r, nil))
+			return false, false
+		}
+		server.writeError(w, r, http.StatusInternalServerError, err, "internal server error")
+		return false, false
+	}
+
+	err = tmpl.Execute(buf, *dot)
+	returned = errors.As(err, &ReturnError{})
+
+	// capture the dot value for the dev error page before cleanup resets it
+	var devErr *devError
+	if err != nil && server.config.Dev && !returned && !errors.As(err, new(ErrorStatus)) {
+		devErr = newDevError("Error executing template "+tmpl.Name(), err, server.sourceFunc(), r, dot)
+	}
+
+	if err = server.bufferDot.cleanup(dot, err); err != nil {
+		log.Warn("error executing template", slog.Any("error", err))
+		server.config.Metrics.templateError(tmpl.Name())
+		if devErr != nil {
+			writeDevError(w, devErr)
+			return false, false
+		}
+		var errSt ErrorStatus
+		if errors.As(err, &errSt) {
+			server.writeError(w, r, int(errSt), err, errSt.Error())
+			return false, false
+		}
+		server.writeError(w, r, http.StatusInternalServerError, err, "internal server error")
+		return false, false
+	}
+	return returned, true
+}
+
+// middlewareHandler executes the middleware template tmpl before next. If tmpl
+// completes normally, the response headers it set are kept and next handles
+// the request. If it exits early with `return`, `.Resp.ReturnStatus`, or
+// `.Resp.ServeContent`, its response is sent instead and next is skipped.
+func middlewareHandler(server *Instance, tmpl *template.Template, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf := bufPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer bufPool.Put(buf)
+
+		// record the middleware's response so it can be discarded except for
+		// headers if the request continues to next
+		rec := httptest.NewRecorder()
+		returned, ok := server.executeBuffered(rec, r, tmpl, buf)
+		maps.Copy(w.Header(), rec.Header())
+		if !ok || returned {
+			if !ok {
+				// executeBuffered wrote the error response to rec
+				buf.Reset()
+			}
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+			w.Write(buf.Bytes())
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// fragment selects the named template to render from fragments for a request
+// that asks for part of a page. The `fragment` query parameter names the
+// template explicitly, and ok is false if there is no such template. Otherwise
+// htmx requests render the template named by the HX-Target header, if there
+// is one. f is nil if the whole page should be rendered.
+func fragment(r *http.Request, fragments map[string]*template.Template) (name string, f *template.Template, ok bool) {
+	if name = r.URL.Query().Get("fragment"); name != "" {
+		f, ok = fragments[name]
+		return
+	}
+	if r.Header.Get("HX-Request") == "true" {
+		if name = r.Header.Get("HX-Target"); name != "" {
+			return name, fragments[name], true
+		}
+	}
+	return "", nil, true
+}
+
+func flushingTemplateHandler(server *Instance, tmpl executor) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := GetLogger(r.Context())
 
 		if r.Header.Get("Accept") != "text/event-stream" {
-			http.Error(w, "SSE endpoint", http.StatusNotAcceptable)
+			server.writeError(w, r, http.StatusNotAcceptable, nil, "SSE endpoint")
 			return
 		}
 
@@ -66,20 +176,138 @@ func flushingTemplateHandler(server *Instance, tmpl *template.Template) http.Han
 		dot, err := server.flusherDot.value(server.config.Ctx, w, r)
 		if err != nil {
 			log.Error("failed to initialize dot value", slog.Any("error", err))
-			http.Error(w, "internal server error", http.StatusInternalServerError)
+			server.writeError(w, r, http.StatusInternalServerError, err, "internal server error")
 			return
 		}
 
+		server.config.Metrics.sseConnected(r.Pattern, 1)
 		err = tmpl.Execute(w, *dot)
+		server.config.Metrics.sseConnected(r.Pattern, -1)
 
 		if err = server.flusherDot.cleanup(dot, err); err != nil {
 			log.Info("error executing template", slog.Any("error", err))
+			server.config.Metrics.templateError(tmpl.Name())
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
+// errorTemplateKey identifies an error template by the directory of the file
+// that defines it and the status code in its name, or `*`.
+type errorTemplateKey struct {
+	dir, status string
+}
+
+// errorTemplate returns the error template for status that is defined in the
+// directory closest to urlpath, or nil if there is none.
+func (server *Instance) errorTemplate(urlpath string, status int) *template.Template {
+	if len(server.errorTemplates) == 0 {
+		return nil
+	}
+	dir := path.Clean("/" + urlpath)
+	if !strings.HasSuffix(urlpath, "/") {
+		dir = path.Dir(dir)
+	}
+	code := strconv.Itoa(status)
+	for {
+		if tmpl, ok := server.errorTemplates[errorTemplateKey{dir, code}]; ok {
+			return tmpl
+		}
+		if tmpl, ok := server.errorTemplates[errorTemplateKey{dir, "*"}]; ok {
+			return tmpl
+		}
+		if dir == "/" {
+			return nil
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// writeError responds to r with the error template for status, or with msg as
+// plain text if there is no error template or it fails.
+func (server *Instance) writeError(w http.ResponseWriter, r *http.Request, status int, err error, msg string) {
+	if tmpl := server.errorTemplate(r.URL.Path, status); tmpl != nil {
+		if err := server.executeError(w, r, tmpl, status, err); err != nil {
+			GetLogger(r.Context()).Warn("error executing error template", slog.String("template_name", tmpl.Name()), slog.Any("error", err))
+		} else {
+			return
+		}
+	}
+	http.Error(w, msg, status)
+}
+
+// executeError executes an error template with a dot that includes .Error. If
+// it returns an error nothing has been written to w.
+func (server *Instance) executeError(w http.ResponseWriter, r *http.Request, tmpl *template.Template, status int, err error) error {
+	r = r.WithContext(context.WithValue(r.Context(), dotErrorKey, DotError{Status: status, Err: err}))
+	dot, err := server.errorDot.value(server.config.Ctx, w, r)
+	if err != nil {
+		return err
+	}
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+
+	err = tmpl.Execute(buf, *dot)
+	if err != nil {
+		server.config.Metrics.templateError(tmpl.Name())
+	}
+	// clear the headers set by http.Error before the template's headers are
+	// written in cleanup
+	w.Header().Del("Content-Type")
+	w.Header().Del("X-Content-Type-Options")
+	if err = server.errorDot.cleanup(dot, err); err != nil {
+		return err
+	}
+
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// route serves r with the router. If there are error templates, the router's
+// plain text responses for requests that don't match any route are replaced
+// by them.
+func (server *Instance) route(w http.ResponseWriter, r *http.Request) {
+	if len(server.errorTemplates) > 0 {
+		if h, pattern := server.router.Handler(r); pattern == "" {
+			h.ServeHTTP(&muxErrorWriter{ResponseWriter: w, server: server, r: r}, r)
+			return
+		}
+	}
+	server.router.ServeHTTP(w, r)
+}
+
+// muxErrorWriter intercepts the 404 Not Found and 405 Method Not Allowed
+// responses written by http.ServeMux and executes the error template for the
+// status instead, if there is one.
+type muxErrorWriter struct {
+	http.ResponseWriter
+	server  *Instance
+	r       *http.Request
+	handled bool
+}
+
+func (w *muxErrorWriter) WriteHeader(status int) {
+	if tmpl := w.server.errorTemplate(w.r.URL.Path, status); tmpl != nil {
+		if err := w.server.executeError(w.ResponseWriter, w.r, tmpl, status, nil); err != nil {
+			GetLogger(w.r.Context()).Warn("error executing error template", slog.String("template_name", tmpl.Name()), slog.Any("error", err))
+		} else {
+			w.handled = true
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *muxErrorWriter) Write(b []byte) (int, error) {
+	if w.handled {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
 func staticFileHandler(fs fs.FS, fileinfo *fileInfo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log := GetLogger(r.Context())
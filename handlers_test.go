@@ -0,0 +1,120 @@
+package xtemplate
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFragments(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		".layout.html": {Data: []byte(`<title>{{block "title" .}}Site{{end}}</title>{{template "content" .}}`)},
+		"list.html":    {Data: []byte(`{{define "title"}}List{{end}}<ul>{{block "items" .}}<li>a</li>{{end}}</ul>`)},
+		"plain.html":   {Data: []byte(`{{define "NOLAYOUT"}}{{end}}<p>{{block "para" .}}text{{end}}</p>`)},
+		"none.html":    {Data: []byte(`{{define "NOLAYOUT"}}{{end}}whole`)},
+	}
+	instance, _, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	tests := []struct {
+		desc    string
+		path    string
+		headers map[string]string
+		status  int
+		want    string
+		vary    bool
+	}{
+		{"whole page", "/list", nil, 200, "<title>List</title><ul><li>a</li></ul>", true},
+		{"query fragment", "/list?fragment=items", nil, 200, "<li>a</li>", true},
+		{"query page content", "/list?fragment=content", nil, 200, "<ul><li>a</li></ul>", true},
+		{"query layout block", "/list?fragment=title", nil, 200, "List", true},
+		{"query missing fragment", "/list?fragment=missing", nil, 404, "", true},
+		{"htmx target", "/list", map[string]string{"HX-Request": "true", "HX-Target": "items"}, 200, "<li>a</li>", true},
+		{"htmx unknown target renders page", "/list", map[string]string{"HX-Request": "true", "HX-Target": "main"}, 200, "<title>List</title><ul><li>a</li></ul>", true},
+		{"target without htmx request", "/list", map[string]string{"HX-Target": "items"}, 200, "<title>List</title><ul><li>a</li></ul>", true},
+		{"query takes precedence", "/list?fragment=title", map[string]string{"HX-Request": "true", "HX-Target": "items"}, 200, "List", true},
+		{"without layout", "/plain?fragment=para", nil, 200, "text", true},
+		{"page without fragments", "/none?fragment=para", nil, 200, "whole", false},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.path, nil)
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			instance.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, w.Code, w.Body)
+			}
+			if got := w.Body.String(); test.status == 200 && got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+			if vary := w.Header().Get("Vary") == "HX-Request, HX-Target"; vary != test.vary {
+				t.Errorf("expected Vary header %t, got %q", test.vary, w.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestErrorTemplates(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.FuncMaps = []template.FuncMap{{"forbid": func() (string, error) { return "", ErrorStatus(403) }}}
+	config.TemplatesFS = fstest.MapFS{
+		".errors.html":   {Data: []byte(`{{define "ERROR *"}}root {{.Error.Status}} {{.Error.StatusText}}{{end}}`)},
+		"a/.errors.html": {Data: []byte(`{{define "ERROR 404"}}a 404 {{.Req.URL.Path}}{{end}}{{define "ERROR 500"}}a 500 {{.Error.Message}}{{end}}`)},
+		"b/.errors.html": {Data: []byte(`{{define "ERROR *"}}b {{.Error.Status}} {{.Error.Message}}{{end}}`)},
+		"a/.pages.html":  {Data: []byte(`{{define "GET /a/fail"}}{{failf "broke"}}{{end}}{{define "GET /a/forbidden"}}{{forbid}}{{end}}`)},
+		"b/.pages.html":  {Data: []byte(`{{define "GET /b/fail"}}{{failf "broke"}}{{end}}`)},
+	}
+	instance, _, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	tests := []struct {
+		method, path string
+		status       int
+		want         string
+	}{
+		{"GET", "/a/missing", 404, "a 404 /a/missing"},
+		{"GET", "/a/sub/missing", 404, "a 404 /a/sub/missing"},
+		{"GET", "/a/fail", 500, "a 500 "},
+		{"GET", "/a/forbidden", 403, "root 403 Forbidden"},
+		{"DELETE", "/a/fail", 405, "root 405 Method Not Allowed"},
+		{"GET", "/b/missing", 404, "b 404 Not Found"},
+		{"GET", "/b/fail", 500, "b 500 template: "},
+		{"GET", "/c/missing", 404, "root 404 Not Found"},
+		{"GET", "/", 404, "root 404 Not Found"},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			instance.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+			if w.Code != test.status {
+				t.Errorf("expected status %d, got %d", test.status, w.Code)
+			}
+			if got := w.Body.String(); !strings.HasPrefix(got, test.want) {
+				t.Errorf("expected body starting with %q, got %q", test.want, got)
+			}
+		})
+	}
+
+	// .Error.Message is the error of a failed template
+	for _, path := range []string{"/a/fail", "/b/fail"} {
+		w := httptest.NewRecorder()
+		instance.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if !strings.Contains(w.Body.String(), "broke") {
+			t.Errorf("expected the error message in the error template for %s, got %q", path, w.Body)
+		}
+	}
+}
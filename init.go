@@ -0,0 +1,148 @@
+package xtemplate
+
+// Initializers are templates named like `INIT [order] name [after dep...]`
+// that an instance executes once while it's being created, after dot providers
+// are initialized and before it serves requests.
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// initTemplate is a template that runs when an instance is created.
+type initTemplate struct {
+	tmpl *template.Template
+	// name is the name of the initializer without the `INIT` keyword and
+	// order, which other initializers refer to in their `after` list.
+	name  string
+	order int
+	after []string
+}
+
+// parseInitName parses a template name like `INIT migrate`, `INIT 10
+// migrate`, or `INIT seed after migrate users`. Initializers without an
+// explicit order have order 0. A name that is only a number, like `INIT 1`,
+// is a name and not an order.
+func parseInitName(tmpl *template.Template) (initTemplate, error) {
+	fields := strings.Fields(strings.TrimPrefix(tmpl.Name(), "INIT"))
+	it := initTemplate{tmpl: tmpl}
+	if len(fields) > 1 {
+		if order, err := strconv.Atoi(fields[0]); err == nil {
+			it.order = order
+			fields = fields[1:]
+		}
+	}
+	if i := slices.Index(fields, "after"); i >= 0 {
+		it.after = fields[i+1:]
+		fields = fields[:i]
+		if len(it.after) == 0 {
+			return it, fmt.Errorf("expected the names of initializers after 'after'")
+		}
+	}
+	if len(fields) == 0 {
+		return it, fmt.Errorf("missing initializer name")
+	}
+	it.name = strings.Join(fields, " ")
+	return it, nil
+}
+
+// sortInitTemplates orders initializers so that each one runs after the
+// initializers it names in its `after` list. Otherwise they run by ascending
+// order, then by name. It fails if an initializer depends on one that doesn't
+// exist or if dependencies form a cycle.
+func sortInitTemplates(inits []initTemplate) ([]initTemplate, error) {
+	slices.SortFunc(inits, func(a, b initTemplate) int {
+		return cmp.Or(cmp.Compare(a.order, b.order), strings.Compare(a.name, b.name))
+	})
+	byName := map[string]int{}
+	for i, it := range inits {
+		if prev, ok := byName[it.name]; ok {
+			return nil, fmt.Errorf("initializer '%s' is defined by both '%s' and '%s'", it.name, inits[prev].tmpl.Name(), it.tmpl.Name())
+		}
+		byName[it.name] = i
+	}
+	for _, it := range inits {
+		for _, dep := range it.after {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("initializer '%s' runs after '%s' which does not exist", it.tmpl.Name(), dep)
+			}
+		}
+	}
+
+	sorted := make([]initTemplate, 0, len(inits))
+	done := make([]bool, len(inits))
+	for len(sorted) < len(inits) {
+		// pick the first initializer in order whose dependencies have all run
+		next := slices.IndexFunc(inits, func(it initTemplate) bool {
+			return !done[byName[it.name]] && !slices.ContainsFunc(it.after, func(dep string) bool { return !done[byName[dep]] })
+		})
+		if next < 0 {
+			var cycle []string
+			for i, it := range inits {
+				if !done[i] {
+					cycle = append(cycle, it.tmpl.Name())
+				}
+			}
+			return nil, fmt.Errorf("initializers have circular dependencies: '%s'", strings.Join(cycle, "', '"))
+		}
+		done[next] = true
+		sorted = append(sorted, inits[next])
+	}
+	return sorted, nil
+}
+
+// maxInitOutputLog is the number of bytes of an initializer's output that are
+// included in the log.
+const maxInitOutputLog = 1024
+
+// runInitializers executes INIT templates in order and saves their output. If
+// Config.InitOnce is set and prev is not nil, initializers that already ran
+// when the first instance was created are skipped and their output is copied
+// from prev; only initializers added since then are executed.
+func (b *builder) runInitializers(prev *Instance) error {
+	inits, err := sortInitTemplates(b.inits)
+	if err != nil {
+		return err
+	}
+	b.initOutputs = make(map[string]string, len(inits))
+	if b.config.InitOnce && prev != nil {
+		inits = slices.DeleteFunc(inits, func(it initTemplate) bool {
+			output, ok := prev.initOutputs[it.name]
+			if ok {
+				b.initOutputs[it.name] = output
+			}
+			return ok
+		})
+		b.config.Logger.Debug("skipped initializers that already ran in the first instance", slog.Int("initializers", len(b.initOutputs)), slog.Int64("prev_id", prev.id))
+	}
+	buf := new(bytes.Buffer)
+	for _, it := range inits {
+		start := time.Now()
+		buf.Reset()
+		w, r := httptest.NewRecorder(), httptest.NewRequest("", "/", nil)
+		val, err := b.bufferDot.value(b.config.Ctx, w, r)
+		if err != nil {
+			return fmt.Errorf("failed to initialize dot value: %w", err)
+		}
+		err = it.tmpl.Execute(buf, *val)
+		if err = b.bufferDot.cleanup(val, err); err != nil {
+			return fmt.Errorf("template initializer '%s' failed: %w", it.tmpl.Name(), err)
+		}
+		output := strings.TrimSpace(buf.String())
+		b.initOutputs[it.name] = output
+		b.TemplateInitializers += 1
+		if len(output) > maxInitOutputLog {
+			output = output[:maxInitOutputLog] + "..."
+		}
+		b.config.Logger.Info("executed initializer", slog.String("template_name", it.tmpl.Name()), slog.Duration("duration", time.Since(start)), slog.Int("rendered_len", buf.Len()), slog.String("output", output))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package xtemplate
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseInitName(t *testing.T) {
+	tests := []struct {
+		name  string
+		want  initTemplate
+		error string
+	}{
+		{"INIT migrate", initTemplate{name: "migrate"}, ""},
+		{"INIT 10 migrate", initTemplate{name: "migrate", order: 10}, ""},
+		{"INIT -5 early", initTemplate{name: "early", order: -5}, ""},
+		{"INIT warm cache", initTemplate{name: "warm cache"}, ""},
+		{"INIT 20 seed after migrate users", initTemplate{name: "seed", order: 20, after: []string{"migrate", "users"}}, ""},
+		{"INIT 1", initTemplate{name: "1"}, ""},
+		{"INIT seed after", initTemplate{}, "expected the names of initializers"},
+		{"INIT after migrate", initTemplate{}, "missing initializer name"},
+		{"INIT 10 after migrate", initTemplate{}, "missing initializer name"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseInitName(template.New(test.name))
+			if test.error != "" {
+				if err == nil || !strings.Contains(err.Error(), test.error) {
+					t.Fatalf("expected error containing %q, got %v", test.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.name != test.want.name || got.order != test.want.order || !slices.Equal(got.after, test.want.after) {
+				t.Errorf("expected name %q order %d after %v, got name %q order %d after %v", test.want.name, test.want.order, test.want.after, got.name, got.order, got.after)
+			}
+		})
+	}
+}
+
+func TestSortInitTemplates(t *testing.T) {
+	tests := []struct {
+		desc  string
+		names []string
+		want  []string
+		error string
+	}{
+		{"by name", []string{"INIT c", "INIT a", "INIT b"}, []string{"a", "b", "c"}, ""},
+		{"by order", []string{"INIT 20 seed", "INIT 10 migrate", "INIT users"}, []string{"users", "migrate", "seed"}, ""},
+		{"after overrides order", []string{"INIT 1 seed after migrate", "INIT 5 migrate"}, []string{"migrate", "seed"}, ""},
+		{"transitive", []string{"INIT a after b", "INIT b after c", "INIT c", "INIT 0 d"}, []string{"c", "b", "a", "d"}, ""},
+		{"multiple dependencies", []string{"INIT seed after users migrate", "INIT users", "INIT 9 migrate"}, []string{"users", "migrate", "seed"}, ""},
+		{"missing dependency", []string{"INIT seed after migrate"}, nil, "'migrate' which does not exist"},
+		{"cycle", []string{"INIT a after b", "INIT b after a", "INIT c"}, nil, "circular dependencies: 'INIT a after b', 'INIT b after a'"},
+		{"self", []string{"INIT a after a"}, nil, "circular dependencies"},
+		{"duplicate name", []string{"INIT migrate", "INIT 10 migrate"}, nil, "is defined by both"},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var inits []initTemplate
+			for _, name := range test.names {
+				it, err := parseInitName(template.New(name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				inits = append(inits, it)
+			}
+			sorted, err := sortInitTemplates(inits)
+			if test.error != "" {
+				if err == nil || !strings.Contains(err.Error(), test.error) {
+					t.Fatalf("expected error containing %q, got %v", test.error, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, it := range sorted {
+				got = append(got, it.name)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestInitOutput(t *testing.T) {
+	var runs int
+	config := New()
+	config.Minify = false
+	config.InitOnce = true
+	config.FuncMaps = []template.FuncMap{{"run": func() int { runs += 1; return runs }}}
+	config.TemplatesFS = fstest.MapFS{
+		".init.html": {Data: []byte(`
+{{- define "INIT seed after migrate"}}seeded after {{.X.InitOutput "migrate"}}{{end}}
+{{- define "INIT 10 migrate"}} migration {{run}} {{end}}
+{{- define "GET /init"}}{{.X.InitOutput "seed"}}{{end}}`)},
+	}
+	server, err := config.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/init", nil))
+		return w.Body.String()
+	}
+	if got := get(); got != "seeded after migration 1" {
+		t.Errorf("expected initializer output, got %q", got)
+	}
+
+	if err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "seeded after migration 1" || runs != 1 {
+		t.Errorf("expected initializers to run once and their output to be kept on reload, got %q after %d runs", got, runs)
+	}
+
+	// initializers added after the first instance still run
+	config.TemplatesFS.(fstest.MapFS)[".warm.html"] = &fstest.MapFile{Data: []byte(`
+{{- define "INIT warm after seed"}}warm {{run}}{{end}}
+{{- define "GET /warm"}}{{.X.InitOutput "warm"}} {{.X.InitOutput "migrate"}}{{end}}`)}
+	if err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/warm", nil))
+	if got := w.Body.String(); got != "warm 2 migration 1" || runs != 2 {
+		t.Errorf("expected only the new initializer to run on reload, got %q after %d runs", got, runs)
+	}
+}
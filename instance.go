@@ -3,19 +3,21 @@ package xtemplate
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"log/slog"
 	"maps"
 	"net/http"
-	"net/http/httptest"
 	"os"
 	"reflect"
-	"regexp"
 	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/Masterminds/sprig/v3"
@@ -24,10 +26,6 @@ import (
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/tdewolff/minify/v2"
-	"github.com/tdewolff/minify/v2/css"
-	"github.com/tdewolff/minify/v2/html"
-	"github.com/tdewolff/minify/v2/js"
-	"github.com/tdewolff/minify/v2/svg"
 )
 
 // Instance is a configured, immutable, xtemplate request handler ready to
@@ -38,6 +36,10 @@ import (
 // mutating a running Instance, build a new Instance from a modified Config and
 // swap them.
 //
+// Call [Instance.Shutdown] when the Instance is no longer needed to release
+// the resources of its dot providers, like database connections and
+// in-process nats servers. They are not released when Config.Ctx is cancelled.
+//
 // See also [Server] which manages instances and enables reloading them.
 type Instance struct {
 	config Config
@@ -47,16 +49,85 @@ type Instance struct {
 	files     map[string]*fileInfo
 	templates *template.Template
 	funcs     template.FuncMap
+	// textTemplates is the namespace of templates parsed with text/template,
+	// see Config.TextTemplateExtension.
+	textTemplates *texttemplate.Template
+	// textMinifier minifies the output of text templates if not nil.
+	textMinifier *minify.M
 
 	natsServer *server.Server
 	natsClient *jetstream.JetStream
 
+	providers  []provider
 	bufferDot  dot
 	flusherDot dot
+	errorDot   dot
+	testDot    dot
+	jobDot     dot
+
+	accessLog *accessLogger
+	// sources maps template file paths to the content that was parsed, only
+	// kept in dev mode to show source excerpts in error pages.
+	sources map[string]string
+	// errorTemplates maps a directory and status code or `*` to the error
+	// template defined in that directory, see DotError.
+	errorTemplates map[errorTemplateKey]*template.Template
+	// routeTemplates maps route patterns to the name of the template that
+	// handles them.
+	routeTemplates map[string]string
+	// tests are the templates named like `TEST name` sorted by name, see
+	// DotTest.
+	tests []*template.Template
+	// problems found while building the instance that don't prevent it from
+	// loading, see Config.Check.
+	problems []Problem
+	// routeURLs maps route patterns and the names of the templates that
+	// handle them to the host and path part of the pattern, see DotX.URL.
+	routeURLs map[string]string
+	// schedules are the templates named like `CRON <spec>` or `EVERY
+	// <duration>` that run periodically, see startSchedules.
+	schedules []scheduledTemplate
+	// initOutputs maps the names of INIT templates to their rendered output,
+	// see DotX.InitOutput.
+	initOutputs map[string]string
+	// jobTemplates maps job names to the templates named like `JOB <name>`
+	// that run them, see DotJob.
+	jobTemplates map[string]*template.Template
+	// jobQueues are the configured job queues whose workers are started by
+	// the instance, see startJobWorkers.
+	jobQueues []*DotJobsConfig
+	// stopBackground stops scheduled templates and job workers, and
+	// background counts the goroutines that run them.
+	stopBackground context.CancelFunc
+	background     sync.WaitGroup
+
+	// inflight is read-locked for the duration of every request. Acquiring
+	// the write lock waits for outstanding requests to finish and refuses new
+	// ones, see drain.
+	inflight sync.RWMutex
+	active   atomic.Int64
+}
+
+// provider is a dot provider that has been initialized by an instance.
+type provider struct {
+	DotConfig
+	// config is the json encoding of the provider before Init was called,
+	// used to detect whether its configuration changed between reloads.
+	config []byte
 }
 
 // Instance creates a new *Instance from the given config
 func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []InstanceRoute, error) {
+	return config.instance(nil, cfgs...)
+}
+
+// instance creates a new *Instance from the given config. If prev is not nil,
+// dot providers that implement [HandoffDotProvider] and whose configuration is
+// unchanged from the provider with the same field name in prev take over its
+// resources instead of calling Init. Adopted providers are removed from prev
+// only if the new instance is created successfully, so prev will not shut
+// them down when it is retired.
+func (config *Config) instance(prev *Instance, cfgs ...Option) (*Instance, *InstanceStats, []InstanceRoute, error) {
 	start := time.Now()
 
 	build := &builder{
@@ -65,12 +136,16 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 			id:     nextInstanceIdentity.Add(1),
 		},
 		InstanceStats: &InstanceStats{},
+		layouts:       map[string]*layoutFile{},
+		middlewares:   map[string]*template.Template{},
 	}
+	build.jobTemplates = map[string]*template.Template{}
 
 	if _, err := build.config.Options(cfgs...); err != nil {
 		return nil, nil, nil, err
 	}
 
+	build.config.Tracer.setLogger(build.config.Logger)
 	build.config.Logger = build.config.Logger.With(slog.Int64("instance", build.id))
 	build.config.Logger.Info("initializing")
 
@@ -88,18 +163,17 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 	}
 
 	build.files = make(map[string]*fileInfo)
+	build.errorTemplates = make(map[errorTemplateKey]*template.Template)
 	build.router = http.NewServeMux()
 	build.templates = template.New(".").Delims(build.config.LDelim, build.config.RDelim).Funcs(build.funcs)
+	build.textTemplates = texttemplate.New(".").Delims(build.config.LDelim, build.config.RDelim).Funcs(texttemplate.FuncMap(build.funcs))
 
 	if config.Minify {
-		m := minify.New()
-		m.Add("text/css", &css.Minifier{})
-		m.Add("image/svg+xml", &svg.Minifier{})
-		m.Add("text/html", &html.Minifier{
-			TemplateDelims: [...]string{build.config.LDelim, build.config.RDelim},
-		})
-		m.AddRegexp(regexp.MustCompile("^(application|text)/(x-)?(java|ecma)script$"), &js.Minifier{})
-		build.m = m
+		build.m = newMinifier(&build.config)
+		build.textMinifier = newOutputMinifier()
+	}
+	if build.config.Dev {
+		build.sources = make(map[string]string)
 	}
 
 	if err := fs.WalkDir(build.config.TemplatesFS, ".", func(path string, d fs.DirEntry, err error) error {
@@ -108,6 +182,8 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 		}
 		if strings.HasSuffix(path, build.config.TemplateExtension) {
 			err = build.addTemplateHandler(path)
+		} else if strings.HasSuffix(path, build.config.TextTemplateExtension) {
+			err = build.addTextTemplateHandler(path)
 		} else {
 			err = build.addStaticFileHandler(path)
 		}
@@ -116,12 +192,34 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 		return nil, nil, nil, fmt.Errorf("error scanning files: %w", err)
 	}
 
+	if err := build.registerRoutes(); err != nil {
+		return nil, nil, nil, err
+	}
+	slices.SortFunc(build.tests, func(a, b *template.Template) int { return strings.Compare(a.Name(), b.Name()) })
+
+	build.routeTemplates = make(map[string]string, len(build.routes))
+	build.routeURLs = make(map[string]string, len(build.routes))
+	for _, route := range build.routes {
+		if route.Template != "" {
+			build.routeTemplates[route.Pattern] = route.Template
+		}
+		hostpath := route.Pattern
+		if _, rest, ok := strings.Cut(route.Pattern, " "); ok {
+			hostpath = strings.TrimLeft(rest, " \t")
+		}
+		build.routeURLs[strings.Join(strings.Fields(route.Pattern), " ")] = hostpath
+		if route.Template != "" {
+			build.routeURLs[strings.Join(strings.Fields(route.Template), " ")] = hostpath
+		}
+	}
+
 	dcInstance := dotXProvider{build.Instance}
 	dcReq := dotReqProvider{}
 	dcResp := dotRespProvider{}
 	dcFlush := dotFlushProvider{}
 
 	var dot []DotConfig
+	var adopted []provider
 
 	{
 		names := map[string]int{}
@@ -141,6 +239,18 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 			dot = append(dot, &d)
 			names[d.FieldName()] += 1
 		}
+		for _, d := range build.config.Jobs {
+			d.instance = build.Instance
+			d.lookup = func(name string) DotConfig {
+				if i := slices.IndexFunc(dot, func(p DotConfig) bool { return p.FieldName() == name }); i >= 0 {
+					return dot[i]
+				}
+				return nil
+			}
+			dot = append(dot, &d)
+			build.jobQueues = append(build.jobQueues, &d)
+			names[d.FieldName()] += 1
+		}
 		for _, d := range build.config.CustomProviders {
 			dot = append(dot, d)
 			names[d.FieldName()] += 1
@@ -150,42 +260,78 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 				return nil, nil, nil, fmt.Errorf("dot field name '%s' is used %d times", name, count)
 			}
 		}
+		prevProviders := map[string]provider{}
+		if prev != nil {
+			for _, p := range prev.providers {
+				prevProviders[p.FieldName()] = p
+			}
+		}
 		for _, d := range dot {
-			err := d.Init(build.config.Ctx)
+			// an error means the config can't be compared, so never hand off
+			cfg, err := json.Marshal(d)
+			if _, ok := d.(HandoffDotProvider); ok && err != nil && prev != nil {
+				build.config.Logger.Warn("can't compare dot provider config with the previous instance, initializing it again instead of handing off", slog.String("field", d.FieldName()), slog.Any("error", err))
+			}
+			if p, ok := prevProviders[d.FieldName()]; ok && sameProvider(d, p.DotConfig) {
+				// custom providers passed by pointer are shared by every
+				// instance, so they're already initialized and must not be
+				// shut down by prev
+				adopted = append(adopted, provider{d, cfg})
+				continue
+			}
+			if p, ok := prevProviders[d.FieldName()]; ok && cfg != nil && bytes.Equal(cfg, p.config) {
+				if h, ok := d.(HandoffDotProvider); ok && h.Handoff(p.DotConfig) {
+					build.config.Logger.Debug("handed off dot provider from previous instance", slog.String("field", d.FieldName()), slog.Int64("prev_id", prev.id))
+					adopted = append(adopted, provider{d, cfg})
+					continue
+				}
+			}
+			err = d.Init(build.config.Ctx)
 			if err != nil {
+				build.Shutdown(build.config.Ctx)
 				return nil, nil, nil, fmt.Errorf("failed to initialize dot field '%s': %w", d.FieldName(), err)
 			}
+			build.providers = append(build.providers, provider{d, cfg})
+		}
+	}
+
+	{
+		var err error
+		if build.accessLog, err = newAccessLogger(&build.config); err != nil {
+			build.Shutdown(build.config.Ctx)
+			return nil, nil, nil, err
 		}
 	}
 
 	build.bufferDot = makeDot(slices.Concat([]DotConfig{dcInstance, dcReq}, dot, []DotConfig{dcResp}))
 	build.flusherDot = makeDot(slices.Concat([]DotConfig{dcInstance, dcReq}, dot, []DotConfig{dcFlush}))
+	build.errorDot = makeDot(slices.Concat([]DotConfig{dcInstance, dcReq}, dot, []DotConfig{dcResp, dotErrorProvider{}}))
+	build.testDot = makeDot(slices.Concat([]DotConfig{dcInstance, dcReq}, dot, []DotConfig{dcResp, dotTestProvider{build.Instance}}))
+	build.jobDot = makeDot(slices.Concat([]DotConfig{dcInstance, dcReq}, dot, []DotConfig{dcResp, dotJobProvider{}}))
+	if len(build.jobTemplates) > 0 && len(build.jobQueues) == 0 {
+		build.config.Logger.Warn("job templates are defined but no jobs queue is configured to run them", slog.Int("job_templates", len(build.jobTemplates)))
+	}
 
-	{
-		// Invoke all initilization templates, aka any template whose name starts
-		// with "INIT ".
-		makeDot := func() (*reflect.Value, error) {
-			w, r := httptest.NewRecorder(), httptest.NewRequest("", "/", nil)
-			return build.bufferDot.value(build.config.Ctx, w, r)
-		}
-		cleanup := build.bufferDot.cleanup
-		buf := new(bytes.Buffer)
-		for _, tmpl := range build.templates.Templates() {
-			buf.Reset()
-			if strings.HasPrefix(tmpl.Name(), "INIT ") {
-				val, err := makeDot()
-				if err != nil {
-					return nil, nil, nil, fmt.Errorf("failed to initialize dot value: %w", err)
-				}
-				err = tmpl.Execute(buf, *val)
-				if err = cleanup(val, err); err != nil {
-					return nil, nil, nil, fmt.Errorf("template initializer '%s' failed: %w", tmpl.Name(), err)
-				}
-				// TODO: output buffer somewhere?
-				build.config.Logger.Debug("executed initializer", slog.String("template_name", tmpl.Name()), slog.Int("rendered_len", buf.Len()))
-				build.TemplateInitializers += 1
-			}
-		}
+	if err := build.runInitializers(prev); err != nil {
+		build.Shutdown(build.config.Ctx)
+		return nil, nil, nil, err
+	}
+
+	// The new instance is valid, take ownership of the adopted providers.
+	if len(adopted) > 0 {
+		prev.providers = slices.DeleteFunc(prev.providers, func(p provider) bool {
+			return slices.ContainsFunc(adopted, func(a provider) bool { return a.FieldName() == p.FieldName() })
+		})
+		build.providers = append(build.providers, adopted...)
+	}
+
+	if build.config.noBackground {
+		build.config.Logger.Debug("not running scheduled templates or job workers", slog.Int("schedules", len(build.schedules)), slog.Int("job_queues", len(build.jobQueues)))
+	} else {
+		var ctx context.Context
+		ctx, build.stopBackground = context.WithCancel(build.config.Ctx)
+		build.startSchedules(ctx)
+		build.startJobWorkers(ctx)
 	}
 
 	build.config.Logger.Info("instance loaded",
@@ -195,6 +341,11 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 			slog.Int("templateFiles", build.TemplateFiles),
 			slog.Int("templateDefinitions", build.TemplateDefinitions),
 			slog.Int("templateInitializers", build.TemplateInitializers),
+			slog.Int("errorTemplates", build.ErrorTemplates),
+			slog.Int("middlewares", build.Middlewares),
+			slog.Int("layoutPages", build.LayoutPages),
+			slog.Int("scheduledTemplates", build.ScheduledTemplates),
+			slog.Int("jobTemplates", build.JobTemplates),
 			slog.Int("staticFiles", build.StaticFiles),
 			slog.Int("staticFilesAlternateEncodings", build.StaticFilesAlternateEncodings),
 		))
@@ -202,6 +353,12 @@ func (config *Config) Instance(cfgs ...Option) (*Instance, *InstanceStats, []Ins
 	return build.Instance, build.InstanceStats, build.routes, nil
 }
 
+// sameProvider reports whether a and b are the same pointer.
+func sameProvider(a, b DotConfig) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	return va.Kind() == reflect.Pointer && vb.Kind() == reflect.Pointer && va.Type() == vb.Type() && va.Pointer() == vb.Pointer()
+}
+
 // Counter to assign a unique id to each instance of xtemplate created when
 // calling Config.Instance(). This is intended to help distinguish logs from
 // multiple instances in a single process.
@@ -215,11 +372,60 @@ func (x *Instance) Id() int64 {
 	return x.id
 }
 
+// Shutdown stops running scheduled templates and job workers and waits for any
+// that are executing to finish, then calls Shutdown on every dot provider that
+// implements [ShutdownDotProvider] to release the resources they acquired
+// during Init. Call it only after the instance's Config.Ctx has been cancelled
+// and it has stopped serving requests; [Server] does this automatically when
+// an instance is replaced or stopped.
+func (x *Instance) Shutdown(ctx context.Context) error {
+	if x.stopBackground != nil {
+		x.stopBackground()
+		x.background.Wait()
+	}
+	var errs []error
+	for _, d := range x.providers {
+		if sdp, ok := d.DotConfig.(ShutdownDotProvider); ok {
+			if err := sdp.Shutdown(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("failed to shut down dot field '%s': %w", d.FieldName(), err))
+			}
+		}
+	}
+	x.providers = nil
+	if err := x.accessLog.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close access log: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// drain blocks until all requests being served by this instance have
+// completed. Requests that arrive after drain is called are refused. The
+// instance's Config.Ctx should be cancelled first so that long-lived requests
+// like SSE streams are signaled to exit.
+func (x *Instance) drain() {
+	x.inflight.Lock()
+}
+
+// ActiveRequests returns the number of requests currently being served by this
+// instance.
+func (x *Instance) ActiveRequests() int64 {
+	return x.active.Load()
+}
+
 var (
 	levelDebug2 slog.Level = slog.LevelDebug + 2
 )
 
 func (instance *Instance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !instance.inflight.TryRLock() {
+		instance.config.Logger.Error("received request after xtemplate instance drained", slog.String("method", r.Method), slog.String("path", r.URL.Path))
+		http.Error(w, "server stopped", http.StatusInternalServerError)
+		return
+	}
+	defer instance.inflight.RUnlock()
+	instance.active.Add(1)
+	defer instance.active.Add(-1)
+
 	select {
 	case <-instance.config.Ctx.Done():
 		instance.config.Logger.Error("received request after xtemplate instance cancelled", slog.String("method", r.Method), slog.String("path", r.URL.Path))
@@ -244,18 +450,59 @@ func (instance *Instance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		slog.String("requestPath", r.URL.Path),
 	)
 	ctx = context.WithValue(ctx, loggerKey, log)
+	ctx = context.WithValue(ctx, requestValuesKey, map[string]any{})
+	if instance.config.Metrics != nil {
+		ctx = context.WithValue(ctx, metricsKey, instance.config.Metrics)
+	}
+	ctx, span := instance.config.Tracer.startTrace(ctx, r.Method, r.Header)
+	span.SetAttr("http.request.method", r.Method)
+	span.SetAttr("url.path", r.URL.Path)
+	span.SetAttr("xtemplate.request_id", rid)
+	span.SetAttr("xtemplate.instance", instance.id)
 
 	r = r.WithContext(ctx)
-	metrics := httpsnoop.CaptureMetrics(instance.router, w, r)
+	metrics := httpsnoop.CaptureMetrics(http.HandlerFunc(instance.route), w, r)
+	instance.config.Metrics.observeRequest(r.Pattern, r.Method, metrics.Code, metrics.Duration, metrics.Written)
+	if span != nil {
+		// prefix the method unless the pattern already starts with one
+		span.Name = r.Pattern
+		if !strings.ContainsAny(r.Pattern, " \t") {
+			span.Name = strings.TrimSpace(r.Method + " " + r.Pattern)
+		}
+		span.SetAttr("http.route", r.Pattern)
+		span.SetAttr("http.response.status_code", metrics.Code)
+		var err error
+		if metrics.Code >= 500 {
+			err = ErrorStatus(metrics.Code)
+		}
+		span.end(err)
+	}
 
 	log.LogAttrs(r.Context(), levelDebug2, "request served",
 		slog.Group("response",
 			slog.Duration("duration", metrics.Duration),
 			slog.Int("statusCode", metrics.Code),
 			slog.Int64("bytes", metrics.Written),
-			// Uncomment after release with this commit: https://github.com/golang/go/commit/a523152ea1df8d39d923ed90d19662896eff0607
-			// slog.String("pattern", r.Pattern),
+			slog.String("pattern", r.Pattern),
 		))
+
+	if instance.accessLog != nil {
+		instance.accessLog.log(&accessLogEntry{
+			Time:       time.Now().Add(-metrics.Duration),
+			RequestId:  rid,
+			RemoteAddr: instance.accessLog.remoteAddr(r),
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Proto:      r.Proto,
+			Pattern:    r.Pattern,
+			Template:   instance.routeTemplates[r.Pattern],
+			Status:     metrics.Code,
+			Bytes:      metrics.Written,
+			Duration:   metrics.Duration.Seconds(),
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		})
+	}
 }
 
 type requestIdType struct{}
@@ -0,0 +1,65 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+type countingProvider struct {
+	inits, shutdowns int
+}
+
+func (p *countingProvider) FieldName() string                  { return "Counter" }
+func (p *countingProvider) Init(context.Context) error         { p.inits += 1; return nil }
+func (p *countingProvider) Value(Request) (any, error)         { return p.inits, nil }
+func (p *countingProvider) Shutdown(ctx context.Context) error { p.shutdowns += 1; return nil }
+
+func TestSharedCustomProvider(t *testing.T) {
+	p := &countingProvider{}
+	config := New()
+	config.TemplatesFS = fstest.MapFS{}
+	server, err := config.Server(WithProvider(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if err := server.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.retiring.Wait()
+	if p.inits != 1 || p.shutdowns != 0 {
+		t.Errorf("expected a shared provider to be initialized once and not shut down by retired instances, got %d inits and %d shutdowns", p.inits, p.shutdowns)
+	}
+	server.Stop()
+	if p.shutdowns != 1 {
+		t.Errorf("expected the provider to be shut down when the server stops, got %d shutdowns", p.shutdowns)
+	}
+}
+
+func TestHandoffUncomparableConfig(t *testing.T) {
+	var logs bytes.Buffer
+	config := New()
+	config.TemplatesFS = fstest.MapFS{}
+	config.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	// nats.Options has func fields that can't be encoded as json
+	connOpts := nats.GetDefaultOptions()
+	server, err := config.Server(WithNats("Nats", &natsserver.Options{DontListen: true}, &connOpts, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	if err := server.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := logs.String(); !strings.Contains(got, "can't compare dot provider config") || !strings.Contains(got, "field=Nats") {
+		t.Errorf("expected a warning that the nats provider can't be handed off, got %q", got)
+	}
+}
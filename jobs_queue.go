@@ -0,0 +1,256 @@
+package xtemplate
+
+// Storage backends for the job queues configured with DotJobsConfig.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// queuedJob is a job that is stored in a jobQueue.
+type queuedJob struct {
+	ID      string
+	Name    string
+	Payload []byte
+	// Attempt is the number of times the job has been claimed, including the
+	// current attempt.
+	Attempt int
+
+	msg jetstream.Msg
+}
+
+// jobQueue persists jobs until they are completed or dead.
+type jobQueue interface {
+	enqueue(ctx context.Context, job *queuedJob) error
+	// claim returns the next job that is due and reserves it until it is
+	// completed, retried, or dead, or until its timeout expires. It returns
+	// nil if no job is due.
+	claim(ctx context.Context) (*queuedJob, error)
+	complete(ctx context.Context, job *queuedJob) error
+	retry(ctx context.Context, job *queuedJob, delay time.Duration, cause error) error
+	dead(ctx context.Context, job *queuedJob, cause error) error
+}
+
+// sqlJobQueue stores jobs in a table. Completed jobs are deleted and dead jobs
+// are kept with the status `dead` and the error of their last attempt. Times
+// are stored as unix milliseconds.
+type sqlJobQueue struct {
+	db      *sql.DB
+	table   string
+	dialect string
+	timeout time.Duration
+}
+
+func (q *sqlJobQueue) init(ctx context.Context) error {
+	create := `CREATE TABLE IF NOT EXISTS ` + q.table + ` (
+	id VARCHAR(36) NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	payload TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	attempts INTEGER NOT NULL,
+	run_at BIGINT NOT NULL,
+	locked_until BIGINT NOT NULL,
+	last_error TEXT,
+	created_at BIGINT NOT NULL,
+	updated_at BIGINT NOT NULL`
+	index := `CREATE INDEX IF NOT EXISTS ` + q.table + `_due ON ` + q.table + ` (status, run_at)`
+	if q.dialect == "mysql" {
+		// mysql doesn't support IF NOT EXISTS for indexes
+		create += ",\n\tINDEX " + q.table + "_due (status, run_at)"
+		index = ""
+	}
+	if _, err := q.db.ExecContext(ctx, create+"\n)"); err != nil {
+		return err
+	}
+	if index != "" {
+		if _, err := q.db.ExecContext(ctx, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// query rewrites the `?` placeholders of s for the dialect of the database.
+func (q *sqlJobQueue) query(s string) string {
+	return rebindPlaceholders(q.dialect, s)
+}
+
+// sqlExecer is a *sql.DB or a *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (q *sqlJobQueue) enqueue(ctx context.Context, job *queuedJob) error {
+	return q.insert(ctx, q.db, job)
+}
+
+// insert adds job to the table with db, which can be the transaction of the
+// template that enqueues the job so that it's only stored if the template
+// succeeds.
+func (q *sqlJobQueue) insert(ctx context.Context, db sqlExecer, job *queuedJob) error {
+	now := time.Now().UnixMilli()
+	_, err := db.ExecContext(ctx, q.query(`INSERT INTO `+q.table+` (id, name, payload, status, attempts, run_at, locked_until, created_at, updated_at) VALUES (?, ?, ?, 'pending', 0, ?, 0, ?, ?)`),
+		job.ID, job.Name, string(job.Payload), now, now, now)
+	return err
+}
+
+func (q *sqlJobQueue) claim(ctx context.Context) (*queuedJob, error) {
+	// Find a due job, then claim it only if no other worker claimed it first.
+	// Jobs whose worker stopped without finishing them are due again when
+	// their lock expires.
+	for range 3 {
+		now := time.Now().UnixMilli()
+		job := &queuedJob{}
+		var payload string
+		err := q.db.QueryRowContext(ctx, q.query(`SELECT id, name, payload, attempts FROM `+q.table+` WHERE (status = 'pending' AND run_at <= ?) OR (status = 'running' AND locked_until <= ?) ORDER BY run_at LIMIT 1`), now, now).
+			Scan(&job.ID, &job.Name, &payload, &job.Attempt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		res, err := q.db.ExecContext(ctx, q.query(`UPDATE `+q.table+` SET status = 'running', attempts = attempts + 1, locked_until = ?, updated_at = ? WHERE id = ? AND attempts = ?`),
+			now+q.timeout.Milliseconds(), now, job.ID, job.Attempt)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 1 {
+			job.Payload = []byte(payload)
+			job.Attempt += 1
+			return job, nil
+		}
+	}
+	return nil, nil
+}
+
+func (q *sqlJobQueue) complete(ctx context.Context, job *queuedJob) error {
+	_, err := q.db.ExecContext(ctx, q.query(`DELETE FROM `+q.table+` WHERE id = ? AND attempts = ?`), job.ID, job.Attempt)
+	return err
+}
+
+func (q *sqlJobQueue) retry(ctx context.Context, job *queuedJob, delay time.Duration, cause error) error {
+	now := time.Now()
+	_, err := q.db.ExecContext(ctx, q.query(`UPDATE `+q.table+` SET status = 'pending', run_at = ?, locked_until = 0, last_error = ?, updated_at = ? WHERE id = ? AND attempts = ?`),
+		now.Add(delay).UnixMilli(), cause.Error(), now.UnixMilli(), job.ID, job.Attempt)
+	return err
+}
+
+func (q *sqlJobQueue) dead(ctx context.Context, job *queuedJob, cause error) error {
+	_, err := q.db.ExecContext(ctx, q.query(`UPDATE `+q.table+` SET status = 'dead', locked_until = 0, last_error = ?, updated_at = ? WHERE id = ? AND attempts = ?`),
+		cause.Error(), time.Now().UnixMilli(), job.ID, job.Attempt)
+	return err
+}
+
+// rebindPlaceholders rewrites the `?` placeholders in query to `$1`, `$2`,
+// etc. for postgres. Queries must not contain `?` in string literals.
+func rebindPlaceholders(dialect, query string) string {
+	if dialect != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n += 1
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// jetStreamJobQueue stores jobs as messages in a work queue stream that is
+// consumed by a durable consumer shared by all instances. Redelivery after a
+// failure or timeout is handled by JetStream.
+type jetStreamJobQueue struct {
+	js           jetstream.JetStream
+	stream       string
+	timeout      time.Duration
+	pollInterval time.Duration
+
+	consumer jetstream.Consumer
+}
+
+const jobIdHeader = "Xtemplate-Job-Id"
+
+func (q *jetStreamJobQueue) init(ctx context.Context, concurrency int) error {
+	_, err := q.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      q.stream,
+		Subjects:  []string{q.stream + ".>"},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		return err
+	}
+	q.consumer, err = q.js.CreateOrUpdateConsumer(ctx, q.stream, jetstream.ConsumerConfig{
+		Durable:       "xtemplate-jobs",
+		FilterSubject: q.stream + ".pending.>",
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       q.timeout,
+		MaxAckPending: concurrency,
+	})
+	return err
+}
+
+func (q *jetStreamJobQueue) enqueue(ctx context.Context, job *queuedJob) error {
+	msg := nats.NewMsg(q.stream + ".pending." + job.Name)
+	msg.Data = job.Payload
+	msg.Header.Set(jobIdHeader, job.ID)
+	msg.Header.Set(jetstream.MsgIDHeader, job.ID)
+	_, err := q.js.PublishMsg(ctx, msg)
+	return err
+}
+
+func (q *jetStreamJobQueue) claim(ctx context.Context) (*queuedJob, error) {
+	msg, err := q.consumer.Next(jetstream.FetchMaxWait(q.pollInterval))
+	if errors.Is(err, nats.ErrTimeout) || errors.Is(err, jetstream.ErrNoMessages) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	meta, err := msg.Metadata()
+	if err != nil {
+		msg.Nak()
+		return nil, err
+	}
+	name, _ := strings.CutPrefix(msg.Subject(), q.stream+".pending.")
+	return &queuedJob{
+		ID:      msg.Headers().Get(jobIdHeader),
+		Name:    name,
+		Payload: msg.Data(),
+		Attempt: int(meta.NumDelivered),
+		msg:     msg,
+	}, nil
+}
+
+func (q *jetStreamJobQueue) complete(ctx context.Context, job *queuedJob) error {
+	return job.msg.Ack()
+}
+
+func (q *jetStreamJobQueue) retry(ctx context.Context, job *queuedJob, delay time.Duration, cause error) error {
+	return job.msg.NakWithDelay(delay)
+}
+
+// dead publishes the job to the dead letter subject of its name with the
+// error of its last attempt, and removes it from the pending jobs.
+func (q *jetStreamJobQueue) dead(ctx context.Context, job *queuedJob, cause error) error {
+	msg := nats.NewMsg(q.stream + ".dead." + job.Name)
+	msg.Data = job.Payload
+	msg.Header.Set(jobIdHeader, job.ID)
+	msg.Header.Set("Xtemplate-Job-Error", strings.ReplaceAll(cause.Error(), "\n", " "))
+	if _, err := q.js.PublishMsg(ctx, msg); err != nil {
+		return fmt.Errorf("failed to publish dead letter: %w", err)
+	}
+	return job.msg.Term()
+}
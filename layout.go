@@ -0,0 +1,246 @@
+package xtemplate
+
+// Layouts wrap file-routed templates with the `.layout.html` files found in
+// the template's directory and every ancestor directory. They are resolved
+// once while building an instance by composing copies of the layout and page
+// parse trees under names that are scoped to each page.
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template/parse"
+
+	"github.com/tdewolff/minify/v2"
+)
+
+// noLayoutName is the name of a template that a page can define to opt out of
+// being wrapped by layouts, e.g. `{{define "NOLAYOUT"}}{{end}}`.
+const noLayoutName = "NOLAYOUT"
+
+// layoutContentName is the name of the block that a layout renders the
+// content of the next level with.
+const layoutContentName = "content"
+
+// layoutFile is a parsed layout file.
+type layoutFile struct {
+	path  string
+	trees map[string]*parse.Tree
+}
+
+// layoutPath returns the path of the layout file in dir.
+func (b *builder) layoutPath(dir string) string {
+	return path.Join(dir, ".layout"+b.config.TemplateExtension)
+}
+
+// parseTemplateFile reads, minifies with m if it's not nil, and parses the
+// template file at path_, which is relative to the templates FS root.
+func (b *builder) parseTemplateFile(path_ string, m *minify.M) (string, map[string]*parse.Tree, error) {
+	content, err := readTemplate(b.config.TemplatesFS, m, path_)
+	if err != nil {
+		return "", nil, err
+	}
+	path_ = path.Clean("/" + path_)
+	if b.sources != nil {
+		b.sources[path_] = content
+	}
+	// parse each template file manually to have more control over its final
+	// names in the template namespace.
+	trees, err := parse.Parse(path_, content, b.config.LDelim, b.config.RDelim, b.funcs, buliltinsSkeleton)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not parse template file '%s': %v", path_, err)
+	}
+	return path_, trees, nil
+}
+
+// layout returns the layout defined in dir, or nil if there is none. Layouts
+// are parsed once and cached since every page in the directory tree uses them.
+func (b *builder) layout(dir string) (*layoutFile, error) {
+	if l, ok := b.layouts[dir]; ok {
+		return l, nil
+	}
+	fspath := strings.TrimPrefix(b.layoutPath(dir), "/")
+	if _, err := fs.Stat(b.config.TemplatesFS, fspath); err != nil {
+		b.layouts[dir] = nil
+		return nil, nil
+	}
+	path_, trees, err := b.parseTemplateFile(fspath, b.m)
+	if err != nil {
+		return nil, err
+	}
+	l := &layoutFile{path: path_, trees: trees}
+	b.layouts[dir] = l
+	return l, nil
+}
+
+// layoutBlocks returns the names of the templates defined by the layouts that
+// wrap the template file at path_ that the file defines again to override
+// them. A layout file is wrapped by the layouts of its ancestor directories.
+// Hidden files that aren't layouts and pages that opt out of layouts override
+// nothing.
+func (b *builder) layoutBlocks(path_ string, trees map[string]*parse.Tree) (map[string]bool, error) {
+	dir := path.Dir(path_)
+	if path_ == b.layoutPath(dir) {
+		if dir == "/" {
+			return nil, nil
+		}
+		dir = path.Dir(dir)
+	} else if strings.HasPrefix(path.Base(path_), ".") {
+		return nil, nil
+	}
+	if _, ok := trees[noLayoutName]; ok {
+		return nil, nil
+	}
+	overrides := map[string]bool{}
+	for ; ; dir = path.Dir(dir) {
+		l, err := b.layout(dir)
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			for name := range l.trees {
+				if _, ok := trees[name]; ok && name != l.path && name != path_ && !isSpecialTemplateName(name) {
+					overrides[name] = true
+				}
+			}
+		}
+		if dir == "/" {
+			break
+		}
+	}
+	return overrides, nil
+}
+
+// pageTemplates wraps the page at path_ with the layouts in its directory and
+// its ancestors. It returns the template to execute for the page, or nil if no
+// layouts apply, and the templates that are visible from the page by name for
+// rendering fragments.
+//
+// The outermost layout is executed. The top-level content of each inner level
+// fills the `content` block of the level that wraps it; a level can also
+// define `content` explicitly. Every other template name referenced by any
+// level resolves to the definition in the innermost level that defines it, so
+// pages override blocks defined by layouts. Names not defined by any level
+// refer to the shared template namespace as usual.
+func (b *builder) pageTemplates(path_ string, trees map[string]*parse.Tree) (*template.Template, map[string]*template.Template, error) {
+	var levels []*layoutFile
+	if _, ok := trees[noLayoutName]; !ok {
+		for dir := path.Dir(path_); ; dir = path.Dir(dir) {
+			l, err := b.layout(dir)
+			if err != nil {
+				return nil, nil, err
+			}
+			if l != nil {
+				levels = append([]*layoutFile{l}, levels...)
+			}
+			if dir == "/" {
+				break
+			}
+		}
+	}
+	hasLayouts := len(levels) > 0
+	levels = append(levels, &layoutFile{path: path_, trees: trees})
+	page := len(levels) - 1
+
+	scoped := func(k int, name string) string {
+		return path_ + " " + levels[k].path + " " + name
+	}
+	resolve := func(k int, name string) string {
+		if name == layoutContentName {
+			for j := k + 1; j < len(levels); j++ {
+				if !isBlankTree(levels[j].trees[levels[j].path]) {
+					return scoped(j, levels[j].path)
+				}
+				if _, ok := levels[j].trees[name]; ok {
+					return scoped(j, name)
+				}
+			}
+			for j := k; j >= 0; j-- {
+				if _, ok := levels[j].trees[name]; ok {
+					return scoped(j, name)
+				}
+			}
+			return name
+		}
+		for j := len(levels) - 1; j >= 0; j-- {
+			if _, ok := levels[j].trees[name]; ok && name != levels[j].path {
+				return scoped(j, name)
+			}
+		}
+		return name
+	}
+
+	fragments := map[string]*template.Template{}
+	for k, level := range levels {
+		for name, tree := range level.trees {
+			if name == level.path && !hasLayouts || name == noLayoutName {
+				// without layouts the page itself is executed by its own name
+				continue
+			}
+			tree = tree.Copy()
+			renameTemplateCalls(tree.Root, func(name string) string { return resolve(k, name) })
+			if _, err := b.templates.AddParseTree(scoped(k, name), tree); err != nil {
+				return nil, nil, fmt.Errorf("could not add template '%s' from '%s' for '%s': %v", name, level.path, path_, err)
+			}
+		}
+	}
+	for _, level := range levels {
+		for name := range level.trees {
+			if name == level.path || name == noLayoutName || isSpecialTemplateName(name) {
+				continue
+			}
+			fragments[name] = b.templates.Lookup(resolve(page, name))
+		}
+	}
+
+	if !hasLayouts {
+		return nil, fragments, nil
+	}
+	if !isBlankTree(trees[path_]) {
+		// the page's own content fills the layout's content block
+		fragments[layoutContentName] = b.templates.Lookup(scoped(page, path_))
+	}
+	b.LayoutPages += 1
+	return b.templates.Lookup(scoped(0, levels[0].path)), fragments, nil
+}
+
+// isBlankTree reports whether tree has no content other than whitespace.
+func isBlankTree(tree *parse.Tree) bool {
+	if tree == nil || tree.Root == nil {
+		return true
+	}
+	for _, node := range tree.Root.Nodes {
+		text, ok := node.(*parse.TextNode)
+		if !ok || strings.TrimSpace(string(text.Text)) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// renameTemplateCalls replaces the name of every {{template}} action in node
+// with the result of rename.
+func renameTemplateCalls(node parse.Node, rename func(string) string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			renameTemplateCalls(child, rename)
+		}
+	case *parse.IfNode:
+		renameTemplateCalls(n.List, rename)
+		renameTemplateCalls(n.ElseList, rename)
+	case *parse.RangeNode:
+		renameTemplateCalls(n.List, rename)
+		renameTemplateCalls(n.ElseList, rename)
+	case *parse.WithNode:
+		renameTemplateCalls(n.List, rename)
+		renameTemplateCalls(n.ElseList, rename)
+	case *parse.TemplateNode:
+		n.Name = rename(n.Name)
+	}
+}
@@ -0,0 +1,65 @@
+package xtemplate
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLayouts(t *testing.T) {
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		".layout.html":         {Data: []byte(`<title>{{block "title" .}}Site{{end}}</title>{{template "content" .}}`)},
+		"index.html":           {Data: []byte(`home`)},
+		"about.html":           {Data: []byte(`{{define "title"}}About{{end}}about`)},
+		"raw.html":             {Data: []byte(`{{define "NOLAYOUT"}}{{end}}raw {{block "title" .}}Raw{{end}}`)},
+		"blog/.layout.html":    {Data: []byte(`<main>{{template "content" .}}</main>{{define "title"}}Blog: {{template "post-title" .}}{{end}}{{define "post-title"}}All{{end}}`)},
+		"blog/index.html":      {Data: []byte(`posts`)},
+		"blog/first.html":      {Data: []byte(`{{define "post-title"}}First{{end}}first post`)},
+		"blog/empty.html":      {Data: []byte(`{{define "content"}}explicit{{end}}`)},
+		"blog/draft/post.html": {Data: []byte(`draft`)},
+	}
+	instance, stats, routes, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "<title>Site</title>home"},
+		{"/about", "<title>About</title>about"},
+		{"/raw", "raw Raw"},
+		{"/blog", "<title>Blog: All</title><main>posts</main>"},
+		// pages override blocks defined by any layout that wraps them
+		{"/blog/first", "<title>Blog: First</title><main>first post</main>"},
+		{"/blog/empty", "<title>Blog: All</title><main>explicit</main>"},
+		// directories without a layout use their ancestors' layouts
+		{"/blog/draft/post", "<title>Blog: All</title><main>draft</main>"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		instance.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != 200 {
+			t.Errorf("%s: expected status 200, got %d: %s", test.path, w.Code, w.Body)
+			continue
+		}
+		if got := w.Body.String(); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.path, test.want, got)
+		}
+	}
+	if stats.LayoutPages != 6 {
+		t.Errorf("expected 6 pages with layouts, got %d", stats.LayoutPages)
+	}
+
+	// layouts are not routes themselves
+	for _, route := range routes {
+		if strings.Contains(route.Pattern, ".layout") {
+			t.Errorf("expected layouts to not be routed, got route %s", route.Pattern)
+		}
+	}
+}
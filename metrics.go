@@ -0,0 +1,260 @@
+package xtemplate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics collects request, template, dot provider, and reload metrics from
+// every instance created from a [Config] that references it, and serves them
+// in the Prometheus text exposition format. Since it is shared between
+// instances, metrics persist across reloads. Create one with [NewMetrics] and
+// add it to a config with [WithMetrics]. A nil *Metrics discards all metrics.
+type Metrics struct {
+	requestDuration *metricFamily
+	responseSize    *metricFamily
+	templateErrors  *metricFamily
+	reloads         *metricFamily
+	reloadDuration  *metricFamily
+	dbQueries       *metricFamily
+	dbQueryDuration *metricFamily
+	sseConnections  *metricFamily
+
+	families []*metricFamily
+}
+
+var (
+	durationBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	sizeBuckets     = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+)
+
+// NewMetrics creates a new, empty set of metrics.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		requestDuration: newMetricFamily("xtemplate_http_request_duration_seconds", "Time to serve http requests by route pattern.", "histogram", durationBuckets, "pattern", "method", "code"),
+		responseSize:    newMetricFamily("xtemplate_http_response_size_bytes", "Size of http response bodies by route pattern.", "histogram", sizeBuckets, "pattern", "method", "code"),
+		templateErrors:  newMetricFamily("xtemplate_template_errors_total", "Number of template executions that failed with an error.", "counter", nil, "template"),
+		reloads:         newMetricFamily("xtemplate_reloads_total", "Number of server reloads by result.", "counter", nil, "result"),
+		reloadDuration:  newMetricFamily("xtemplate_reload_duration_seconds", "Time to load a new instance.", "histogram", durationBuckets, "result"),
+		dbQueries:       newMetricFamily("xtemplate_db_queries_total", "Number of database statements executed by dot field name, method, and result.", "counter", nil, "db", "method", "result"),
+		dbQueryDuration: newMetricFamily("xtemplate_db_query_duration_seconds", "Time to execute database statements by dot field name and method.", "histogram", durationBuckets, "db", "method"),
+		sseConnections:  newMetricFamily("xtemplate_sse_connections_active", "Number of open server-sent event connections by route pattern.", "gauge", nil, "pattern"),
+	}
+	m.families = []*metricFamily{m.requestDuration, m.responseSize, m.templateErrors, m.reloads, m.reloadDuration, m.dbQueries, m.dbQueryDuration, m.sseConnections}
+	return m
+}
+
+// WithMetrics creates an [xtemplate.Option] that records metrics to m.
+func WithMetrics(m *Metrics) Option {
+	return func(c *Config) error {
+		if m == nil {
+			return fmt.Errorf("nil metrics")
+		}
+		c.Metrics = m
+		return nil
+	}
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes all metrics to w in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+	var b strings.Builder
+	for _, f := range m.families {
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) observeRequest(pattern, method string, code int, duration time.Duration, size int64) {
+	if m == nil {
+		return
+	}
+	c := strconv.Itoa(code)
+	method = metricMethod(pattern, method)
+	m.requestDuration.observe(duration.Seconds(), pattern, method, c)
+	m.responseSize.observe(float64(size), pattern, method, c)
+}
+
+// metricMethod returns method if it's a standard method or the method of the
+// route pattern that matched the request, and `OTHER` otherwise, so that
+// clients can't create unlimited label values with arbitrary methods.
+func metricMethod(pattern, method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	if strings.HasPrefix(pattern, method+" ") {
+		return method
+	}
+	return "OTHER"
+}
+
+func (m *Metrics) templateError(name string) {
+	if m == nil {
+		return
+	}
+	m.templateErrors.add(1, name)
+}
+
+func (m *Metrics) observeReload(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.reloads.add(1, result)
+	m.reloadDuration.observe(duration.Seconds(), result)
+}
+
+func (m *Metrics) observeQuery(db, method string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.dbQueries.add(1, db, method, result)
+	m.dbQueryDuration.observe(duration.Seconds(), db, method)
+}
+
+func (m *Metrics) sseConnected(pattern string, delta float64) {
+	if m == nil {
+		return
+	}
+	m.sseConnections.add(delta, pattern)
+}
+
+type metricsType struct{}
+
+var metricsKey = metricsType{}
+
+// getMetrics returns the metrics attached to the request context by
+// [Instance.ServeHTTP], or nil.
+func getMetrics(ctx context.Context) *Metrics {
+	m, _ := ctx.Value(metricsKey).(*Metrics)
+	return m
+}
+
+// metricFamily is a set of counter, gauge, or histogram series with the same
+// name that are distinguished by their label values.
+type metricFamily struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64
+
+	mutex  sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64  // counter or gauge value, or histogram sum
+	counts      []uint64 // histogram bucket counts, not cumulative
+	count       uint64   // histogram observation count
+}
+
+func newMetricFamily(name, help, kind string, buckets []float64, labels ...string) *metricFamily {
+	return &metricFamily{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*metricSeries{}}
+}
+
+// get returns the series for labelValues, creating it if necessary. The caller
+// must hold f.mutex.
+func (f *metricFamily) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *metricFamily) add(v float64, labelValues ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.get(labelValues).value += v
+}
+
+func (f *metricFamily) observe(v float64, labelValues ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	s := f.get(labelValues)
+	s.value += v
+	s.count += 1
+	if i, _ := slices.BinarySearch(f.buckets, v); i < len(f.buckets) {
+		s.counts[i] += 1
+	}
+}
+
+func (f *metricFamily) write(b *strings.Builder) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		labels := formatLabels(f.labels, s.labelValues)
+		if f.kind != "histogram" {
+			fmt.Fprintf(b, "%s%s %s\n", f.name, wrapLabels(labels), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, le := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, wrapLabels(labels, `le="`+formatFloat(le)+`"`), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, wrapLabels(labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", f.name, wrapLabels(labels), formatFloat(s.value))
+		fmt.Fprintf(b, "%s_count%s %d\n", f.name, wrapLabels(labels), s.count)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) []string {
+	labels := make([]string, len(names))
+	for i, name := range names {
+		labels[i] = name + `="` + labelValueEscaper.Replace(values[i]) + `"`
+	}
+	return labels
+}
+
+func wrapLabels(labels []string, extra ...string) string {
+	all := append(slices.Clip(labels), extra...)
+	if len(all) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(all, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
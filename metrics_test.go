@@ -0,0 +1,58 @@
+package xtemplate
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"index.html": {Data: []byte(`{{define "QUERY /search"}}found{{end}}{{define "GET /fail"}}{{failf "nope"}}{{end}}hello`)},
+	}
+	instance, _, _, err := config.Instance(WithMetrics(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	for _, req := range [][2]string{{"GET", "/"}, {"GET", "/"}, {"QUERY", "/search"}, {"BREW", "/"}, {"GET", "/fail"}} {
+		instance.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req[0], req[1], nil))
+	}
+
+	var b strings.Builder
+	m.WriteTo(&b)
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE xtemplate_http_request_duration_seconds histogram",
+		`xtemplate_http_request_duration_seconds_count{pattern="GET /",method="GET",code="200"} 2`,
+		`xtemplate_http_request_duration_seconds_count{pattern="QUERY /search",method="QUERY",code="200"} 1`,
+		`xtemplate_http_request_duration_seconds_count{pattern="",method="OTHER",code="405"} 1`,
+		`xtemplate_http_request_duration_seconds_bucket{pattern="GET /",method="GET",code="200",le="+Inf"} 2`,
+		`xtemplate_template_errors_total{template="GET /fail"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected metrics to contain %s, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricMethod(t *testing.T) {
+	tests := []struct{ pattern, method, want string }{
+		{"GET /x", "GET", "GET"},
+		{"", "DELETE", "DELETE"},
+		{"QUERY /search", "QUERY", "QUERY"},
+		{"", "QUERY", "OTHER"},
+		{"GET /x", "X-RANDOM-1234", "OTHER"},
+		{"GET /x", "GE", "OTHER"},
+	}
+	for _, test := range tests {
+		if got := metricMethod(test.pattern, test.method); got != test.want {
+			t.Errorf("metricMethod(%q, %q): expected %s, got %s", test.pattern, test.method, test.want, got)
+		}
+	}
+}
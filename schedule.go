@@ -0,0 +1,274 @@
+package xtemplate
+
+// Scheduled templates are templates named like `CRON */5 * * * *` or
+// `EVERY 10m` that an instance executes periodically while it is running.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule computes the times that a scheduled template runs.
+type schedule interface {
+	// next returns the first time after t that the template should run, or
+	// the zero time if it never runs again.
+	next(t time.Time) time.Time
+}
+
+// scheduledTemplate is a template that an instance runs on a schedule.
+type scheduledTemplate struct {
+	tmpl     *template.Template
+	schedule schedule
+}
+
+// parseSchedule parses the schedule of a template name like
+// `CRON <minute> <hour> <day of month> <month> <day of week> [label]`,
+// `CRON @daily [label]`, or `EVERY <duration> [label]`. The optional label
+// lets multiple templates use the same schedule.
+func parseSchedule(name string) (schedule, error) {
+	keyword, spec, _ := strings.Cut(name, " ")
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing schedule")
+	}
+	switch keyword {
+	case "EVERY":
+		d, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("duration must be positive, got %s", d)
+		}
+		return everySchedule(d), nil
+	case "CRON":
+		if strings.HasPrefix(fields[0], "@") {
+			expr, ok := cronDescriptors[fields[0]]
+			if !ok {
+				return nil, fmt.Errorf("unknown cron descriptor '%s'", fields[0])
+			}
+			fields = strings.Fields(expr)
+		}
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("cron schedule must have 5 fields: minute, hour, day of month, month, and day of week")
+		}
+		return parseCron(fields[:5])
+	}
+	return nil, fmt.Errorf("unknown schedule keyword '%s'", keyword)
+}
+
+// everySchedule runs a template repeatedly with a fixed delay.
+type everySchedule time.Duration
+
+func (d everySchedule) next(t time.Time) time.Time {
+	return t.Add(time.Duration(d))
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is a standard cron schedule in the local time zone. Each field
+// is a bit set of the values that match.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, if both day of month and day of week are restricted, a day
+	// matches if either of them matches.
+	domAny, dowAny bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = [5]cronField{
+	{"minute", 0, 59, nil},
+	{"hour", 0, 23, nil},
+	{"day of month", 1, 31, nil},
+	{"month", 1, 12, []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 is also sunday
+	{"day of week", 0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+func parseCron(fields []string) (*cronSchedule, error) {
+	var bits [5]uint64
+	for i, f := range cronFields {
+		var err error
+		if bits[i], err = f.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %w", f.name, fields[i], err)
+		}
+	}
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cronSchedule{
+		minute: bits[0], hour: bits[1], dom: bits[2], month: bits[3], dow: bits[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parse parses a comma separated list of values, ranges like `1-5`, or `*`,
+// each optionally followed by a step like `/15`.
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s'", stepStr)
+			}
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(loStr); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(hiStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range '%s'", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("value '%s' is out of range %d-%d", s, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// a schedule that matches no date, like `0 0 30 2 *`, never runs
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// startSchedules runs each scheduled template in its own goroutine until ctx
+// is cancelled.
+func (x *Instance) startSchedules(ctx context.Context) {
+	for _, s := range x.schedules {
+		x.background.Add(1)
+		go x.runSchedule(ctx, s)
+	}
+}
+
+// runSchedule executes s every time it's scheduled. The next run is scheduled
+// after the previous run finishes, so runs never overlap; runs that would have
+// started while the previous run was still executing are skipped.
+func (x *Instance) runSchedule(ctx context.Context, s scheduledTemplate) {
+	defer x.background.Done()
+	log := x.config.Logger.With(slog.String("template_name", s.tmpl.Name()))
+	next := s.schedule.next(time.Now())
+	for {
+		if next.IsZero() {
+			log.Warn("scheduled template will never run again")
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		// hold a read lock like a request so the instance waits for the run
+		// to finish before shutting down its providers, see drain
+		if !x.inflight.TryRLock() {
+			return
+		}
+		x.runScheduled(ctx, s.tmpl, log)
+		x.inflight.RUnlock()
+
+		now := time.Now()
+		following := s.schedule.next(next)
+		if !following.IsZero() && following.Before(now) {
+			log.Warn("skipped scheduled runs that would overlap the previous run", slog.Time("scheduled", following))
+			following = s.schedule.next(now)
+		}
+		next = following
+	}
+}
+
+// runScheduled executes tmpl once with the same dot as INIT templates.
+func (x *Instance) runScheduled(ctx context.Context, tmpl *template.Template, log *slog.Logger) {
+	start := time.Now()
+	ctx = context.WithValue(ctx, loggerKey, log)
+	w, r := httptest.NewRecorder(), httptest.NewRequest("", "/", nil).WithContext(ctx)
+	buf := new(bytes.Buffer)
+	dot, err := x.bufferDot.value(ctx, w, r)
+	if err == nil {
+		err = tmpl.Execute(buf, *dot)
+		if errors.As(err, &ReturnError{}) {
+			err = nil
+		}
+		err = x.bufferDot.cleanup(dot, err)
+	}
+	if err != nil {
+		x.config.Metrics.templateError(tmpl.Name())
+		log.Error("scheduled template failed", slog.Duration("duration", time.Since(start)), slog.Any("error", err))
+		return
+	}
+	log.Info("executed scheduled template", slog.Duration("duration", time.Since(start)), slog.Int("rendered_len", buf.Len()))
+}
@@ -0,0 +1,131 @@
+package xtemplate
+
+import (
+	"html/template"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 10, 7, 30, 0, time.UTC) // a wednesday
+	tests := []struct {
+		name string
+		want []time.Time
+	}{
+		{"EVERY 10m", []time.Time{start.Add(10 * time.Minute), start.Add(20 * time.Minute)}},
+		{"EVERY 1h30m warm cache", []time.Time{start.Add(90 * time.Minute)}},
+		{"CRON */5 * * * *", []time.Time{
+			time.Date(2024, time.January, 31, 10, 10, 0, 0, time.UTC),
+			time.Date(2024, time.January, 31, 10, 15, 0, 0, time.UTC),
+		}},
+		{"CRON 0 9 * * mon-fri digest", []time.Time{
+			time.Date(2024, time.February, 1, 9, 0, 0, 0, time.UTC),
+			time.Date(2024, time.February, 2, 9, 0, 0, 0, time.UTC),
+			time.Date(2024, time.February, 5, 9, 0, 0, 0, time.UTC),
+		}},
+		{"CRON 30 2 29 2 *", []time.Time{
+			time.Date(2024, time.February, 29, 2, 30, 0, 0, time.UTC),
+			time.Date(2028, time.February, 29, 2, 30, 0, 0, time.UTC),
+		}},
+		// day of month or day of week when both are restricted
+		{"CRON 0 0 1 * 7", []time.Time{
+			time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.February, 11, 0, 0, 0, 0, time.UTC),
+		}},
+		{"CRON @monthly", []time.Time{
+			time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		{"CRON 0 0 30 2 *", []time.Time{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := start
+			for i, want := range tt.want {
+				if next = s.next(next); !next.Equal(want) {
+					t.Fatalf("run %d: expected %s, got %s", i, want, next)
+				}
+			}
+		})
+	}
+
+	for _, name := range []string{
+		"EVERY",
+		"EVERY soon",
+		"EVERY -1m",
+		"CRON * * * *",
+		"CRON 60 * * * *",
+		"CRON 5-1 * * * *",
+		"CRON */0 * * * *",
+		"CRON * * * foo *",
+		"CRON @often",
+	} {
+		if _, err := parseSchedule(name); err == nil {
+			t.Errorf("expected '%s' to be invalid", name)
+		}
+	}
+}
+
+func TestScheduledTemplates(t *testing.T) {
+	var runs atomic.Int64
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"jobs.html": {Data: []byte(`{{define "EVERY 10ms"}}{{run}}{{end}}`)},
+	}
+	config.FuncMaps = append(config.FuncMaps, template.FuncMap{"run": func() string { runs.Add(1); return "" }})
+	instance, stats, _, err := config.Instance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ScheduledTemplates != 1 {
+		t.Errorf("expected 1 scheduled template, got %d", stats.ScheduledTemplates)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := instance.Shutdown(config.Ctx); err != nil {
+		t.Fatal(err)
+	}
+	count := runs.Load()
+	if count < 2 {
+		t.Errorf("expected the template to run repeatedly, ran %d times", count)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if runs.Load() != count {
+		t.Errorf("expected runs to stop after shutdown")
+	}
+}
+
+func TestWithoutBackground(t *testing.T) {
+	var runs atomic.Int64
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"jobs.html": {Data: []byte(`{{define "EVERY 10ms"}}{{run}}{{end}}{{define "TEST runs"}}{{end}}`)},
+	}
+	config.FuncMaps = append(config.FuncMaps, template.FuncMap{"run": func() string { runs.Add(1); return "" }})
+
+	instance, _, _, err := config.Instance(WithoutBackground())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := config.Check(); len(problems) != 0 {
+		t.Errorf("expected no problems, got %+v", problems)
+	}
+	if _, err := config.RunTests(""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := instance.Shutdown(config.Ctx); err != nil {
+		t.Fatal(err)
+	}
+	if runs.Load() != 0 {
+		t.Errorf("expected scheduled templates to not run, ran %d times", runs.Load())
+	}
+}
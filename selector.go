@@ -0,0 +1,281 @@
+package xtemplate
+
+// A small css selector engine used by test templates to find elements in html
+// responses, see TestResponse.Find. It supports type, `*`, `#id`, `.class`,
+// `[attr]`, `[attr=value]`, `[attr~=value]`, `[attr^=value]`, `[attr$=value]`
+// and `[attr*=value]` selectors combined with descendant and `>` child
+// combinators, and selector lists separated by commas.
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// selector is a list of alternative complex selectors.
+type selector [][]compoundSelector
+
+// compoundSelector matches a single element. combinator is the relation to
+// the element matched by the previous compound selector: ' ' for a
+// descendant, '>' for a child, or 0 for the first compound.
+type compoundSelector struct {
+	combinator byte
+	tag        string
+	id         string
+	classes    []string
+	attrs      []attrSelector
+}
+
+type attrSelector struct {
+	name, op, value string
+}
+
+// parseSelector parses a css selector.
+func parseSelector(s string) (selector, error) {
+	var sel selector
+	for _, part := range strings.Split(s, ",") {
+		complex, err := parseComplexSelector(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector '%s': %w", s, err)
+		}
+		sel = append(sel, complex)
+	}
+	return sel, nil
+}
+
+func parseComplexSelector(s string) ([]compoundSelector, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty selector")
+	}
+	var compounds []compoundSelector
+	var combinator byte
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			if combinator == 0 && len(compounds) > 0 {
+				combinator = ' '
+			}
+			i++
+			continue
+		case c == '>':
+			if len(compounds) == 0 {
+				return nil, fmt.Errorf("unexpected '>'")
+			}
+			combinator = '>'
+			i++
+			continue
+		}
+		compound, n, err := parseCompoundSelector(s[i:])
+		if err != nil {
+			return nil, err
+		}
+		compound.combinator = combinator
+		compounds = append(compounds, compound)
+		combinator = 0
+		i += n
+	}
+	if combinator == '>' {
+		return nil, fmt.Errorf("selector ends with '>'")
+	}
+	return compounds, nil
+}
+
+// parseCompoundSelector parses the compound selector at the start of s and
+// returns the number of bytes consumed.
+func parseCompoundSelector(s string) (compoundSelector, int, error) {
+	var c compoundSelector
+	i := 0
+	if i < len(s) && s[i] == '*' {
+		i++
+	} else {
+		n := identLen(s[i:])
+		c.tag = strings.ToLower(s[i : i+n])
+		i += n
+	}
+	for i < len(s) {
+		switch s[i] {
+		case '#', '.':
+			n := identLen(s[i+1:])
+			if n == 0 {
+				return c, 0, fmt.Errorf("expected name after '%c'", s[i])
+			}
+			if s[i] == '#' {
+				c.id = s[i+1 : i+1+n]
+			} else {
+				c.classes = append(c.classes, s[i+1:i+1+n])
+			}
+			i += 1 + n
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return c, 0, fmt.Errorf("unterminated '['")
+			}
+			attr, err := parseAttrSelector(s[i+1 : i+end])
+			if err != nil {
+				return c, 0, err
+			}
+			c.attrs = append(c.attrs, attr)
+			i += end + 1
+		case ' ', '\t', '\n', '>':
+			return c, i, nil
+		default:
+			return c, 0, fmt.Errorf("unexpected '%c'", s[i])
+		}
+	}
+	if i == 0 {
+		return c, 0, fmt.Errorf("empty compound selector")
+	}
+	return c, i, nil
+}
+
+func parseAttrSelector(s string) (attrSelector, error) {
+	i := strings.IndexAny(s, "~^$*=")
+	if i < 0 {
+		i = len(s)
+	}
+	a := attrSelector{name: strings.ToLower(strings.TrimSpace(s[:i]))}
+	if a.name == "" || identLen(a.name) != len(a.name) {
+		return a, fmt.Errorf("invalid attribute selector '[%s]'", s)
+	}
+	rest := s[i:]
+	if rest == "" {
+		return a, nil
+	}
+	if rest[0] == '=' {
+		a.op, rest = "=", rest[1:]
+	} else if len(rest) > 1 && rest[1] == '=' {
+		a.op, rest = rest[:2], rest[2:]
+	} else {
+		return a, fmt.Errorf("invalid attribute selector '[%s]'", s)
+	}
+	a.value = strings.Trim(strings.TrimSpace(rest), `"'`)
+	return a, nil
+}
+
+func identLen(s string) int {
+	for i, c := range s {
+		if !(c == '-' || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c > 127) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// find returns the elements under root that match the selector in document
+// order.
+func (sel selector) find(root *html.Node) []*html.Node {
+	var found []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && sel.match(n) {
+			found = append(found, n)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		walk(child)
+	}
+	return found
+}
+
+func (sel selector) match(n *html.Node) bool {
+	for _, complex := range sel {
+		if matchComplex(n, complex) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchComplex reports whether n matches the last compound selector and its
+// ancestors match the rest according to the combinators.
+func matchComplex(n *html.Node, compounds []compoundSelector) bool {
+	last := compounds[len(compounds)-1]
+	if !last.match(n) {
+		return false
+	}
+	if len(compounds) == 1 {
+		return true
+	}
+	rest := compounds[:len(compounds)-1]
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if matchComplex(p, rest) {
+			return true
+		}
+		if last.combinator == '>' {
+			break
+		}
+	}
+	return false
+}
+
+func (c compoundSelector) match(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	classes := strings.Fields(attr(n, "class"))
+	for _, class := range c.classes {
+		if !slices.Contains(classes, class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		value, ok := lookupAttr(n, a.name)
+		if !ok {
+			return false
+		}
+		switch a.op {
+		case "=":
+			ok = value == a.value
+		case "~=":
+			ok = slices.Contains(strings.Fields(value), a.value)
+		case "^=":
+			ok = a.value != "" && strings.HasPrefix(value, a.value)
+		case "$=":
+			ok = a.value != "" && strings.HasSuffix(value, a.value)
+		case "*=":
+			ok = a.value != "" && strings.Contains(value, a.value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupAttr(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func attr(n *html.Node, name string) string {
+	value, _ := lookupAttr(n, name)
+	return value
+}
+
+// nodeText returns the text content of n.
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return b.String()
+}
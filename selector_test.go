@@ -0,0 +1,108 @@
+package xtemplate
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		error    string
+	}{
+		{"div", ""},
+		{"*", ""},
+		{"#main", ""},
+		{"div.a.b#c[data-x]", ""},
+		{"ul > li", ""},
+		{"ul>li", ""},
+		{"div  p", ""},
+		{"a, b", ""},
+		{`[href^="https:"]`, ""},
+		{"[ rel ~= 'next' ]", ""},
+		{"", "empty selector"},
+		{"a,,b", "empty selector"},
+		{"> li", "unexpected '>'"},
+		{"ul >", "ends with '>'"},
+		{"div.", "expected name after '.'"},
+		{"#", "expected name after '#'"},
+		{"[href", "unterminated '['"},
+		{"[=x]", "invalid attribute selector"},
+		{"[a!=x]", "invalid attribute selector"},
+		{"[]", "invalid attribute selector"},
+		{"a + b", "unexpected '+'"},
+		{"a:hover", "unexpected ':'"},
+	}
+	for _, test := range tests {
+		_, err := parseSelector(test.selector)
+		if test.error == "" && err != nil {
+			t.Errorf("parseSelector(%q): %v", test.selector, err)
+		}
+		if test.error != "" && (err == nil || !strings.Contains(err.Error(), test.error)) {
+			t.Errorf("parseSelector(%q): expected error containing %q, got %v", test.selector, test.error, err)
+		}
+	}
+}
+
+func TestSelectorFind(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`
+<div id="main" class="page wide">
+  <ul id="list">
+    <li id="one" class="item first" data-n="1">One</li>
+    <li id="two" class="item" data-n="2"><a id="link" href="https://example.com/x" rel="next prev">Two</a></li>
+  </ul>
+  <p id="para"><span id="span">text</span></p>
+</div>
+<footer id="foot" class="page"><a id="out" href="/local">Out</a></footer>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{"li", []string{"one", "two"}},
+		{"LI", []string{"one", "two"}},
+		{"#two", []string{"two"}},
+		{".item", []string{"one", "two"}},
+		{".item.first", []string{"one"}},
+		{".page", []string{"main", "foot"}},
+		{"div.page.wide", []string{"main"}},
+		{"footer.wide", nil},
+		{"ul > li", []string{"one", "two"}},
+		{"div > li", nil},
+		{"div li", []string{"one", "two"}},
+		{"div > ul > li > a", []string{"link"}},
+		{"#main a", []string{"link"}},
+		{"#main > * > span", []string{"span"}},
+		{"a, span", []string{"link", "span", "out"}},
+		{"[data-n]", []string{"one", "two"}},
+		{"[data-n=2]", []string{"two"}},
+		{`[data-n="1"]`, []string{"one"}},
+		{"[rel~=prev]", []string{"link"}},
+		{"[rel~=nex]", nil},
+		{"[href^=https]", []string{"link"}},
+		{"[href$=/x]", []string{"link"}},
+		{"[href*=example]", []string{"link"}},
+		{"[href^='']", nil},
+		{"p span, li.first", []string{"one", "span"}},
+		{"table", nil},
+	}
+	for _, test := range tests {
+		sel, err := parseSelector(test.selector)
+		if err != nil {
+			t.Errorf("parseSelector(%q): %v", test.selector, err)
+			continue
+		}
+		var got []string
+		for _, n := range sel.find(doc) {
+			got = append(got, attr(n, "id"))
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("find(%q): expected %v, got %v", test.selector, test.want, got)
+		}
+	}
+}
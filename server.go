@@ -2,7 +2,9 @@ package xtemplate
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"sync"
 	"sync/atomic"
@@ -16,15 +18,38 @@ import (
 // the old Instance with the new Instance so subsequent requests are handled by
 // the new instance, and any outstanding requests still being served by the old
 // Instance can continue to completion. The old instance's Config.Ctx is also
-// cancelled.
+// cancelled, and once its outstanding requests finish its dot providers are
+// shut down. Dot providers that implement [HandoffDotProvider] and whose config
+// is unchanged carry their resources over to the new Instance instead.
 //
 // The only way to create a valid *Server is to call [Config.Server].
 type Server struct {
 	instance atomic.Pointer[Instance]
 	cancel   func()
+	retiring sync.WaitGroup
 
 	mutex  sync.Mutex
 	config Config
+
+	statusMutex sync.RWMutex
+	status      serverStatus
+}
+
+// serverStatus records the outcome of reloads, see [Server.AdminHandler].
+type serverStatus struct {
+	stats    *InstanceStats
+	routes   []InstanceRoute
+	loadedAt time.Time
+	loadTime time.Duration
+	// reloadErr is the error of the last reload that failed, which is kept
+	// after later reloads succeed. reloadFailed is whether the most recent
+	// reload failed.
+	reloadErr    error
+	reloadErrAt  time.Time
+	reloadFailed bool
+	// sources is set after a failed reload in dev mode, and reads the
+	// templates that failed to load to show in the error page.
+	sources sourceFunc
 }
 
 // Build creates a new Server from an xtemplate.Config.
@@ -52,28 +77,130 @@ func (x *Server) Instance() *Instance {
 	return x.instance.Load()
 }
 
-// Serve opens a net listener on `listen_addr` and serves requests from it.
+// Serve opens a net listener on `listen_addr` and serves requests from it
+// until Config.Ctx is cancelled. See [Server.ServeListeners].
 func (x *Server) Serve(listen_addr string) error {
-	x.config.Logger.Info("starting server")
-	return http.ListenAndServe(listen_addr, x.Handler())
+	ln, err := net.Listen("tcp", listen_addr)
+	if err != nil {
+		return err
+	}
+	return x.ServeListeners(&http.Server{}, ln)
+}
+
+// ServeListeners serves requests from each listener with srv until Config.Ctx
+// is cancelled. Then it gracefully shuts down srv, waiting up to 30 seconds for
+// open connections to finish, and stops the Server. If srv.Handler is nil it is
+// set to [Server.Handler]. If srv.TLSConfig is not nil, connections are served
+// with TLS using the certificates it provides.
+func (x *Server) ServeListeners(srv *http.Server, listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return fmt.Errorf("no listeners to serve")
+	}
+	if srv.Handler == nil {
+		srv.Handler = x.Handler()
+	}
+	x.mutex.Lock()
+	ctx, log := x.config.Ctx, x.config.Logger
+	x.mutex.Unlock()
+	if srv.ErrorLog == nil {
+		srv.ErrorLog = slog.NewLogLogger(log.Handler(), slog.LevelWarn)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		log.Info("shutting down server")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		log.Info("starting server", slog.String("network", ln.Addr().Network()), slog.String("listen_addr", ln.Addr().String()), slog.Bool("tls", srv.TLSConfig != nil))
+		go func(ln net.Listener) {
+			if srv.TLSConfig != nil {
+				errs <- srv.ServeTLS(ln, "", "")
+			} else {
+				errs <- srv.Serve(ln)
+			}
+		}(ln)
+	}
+
+	// The first listener to fail for a reason other than shutdown stops the
+	// rest of them.
+	var err error
+	for range listeners {
+		if e := <-errs; e != http.ErrServerClosed && err == nil {
+			err = e
+			srv.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	err = <-shutdown
+	x.Stop()
+	return err
 }
 
 // Handler returns a `http.Handler` that always routes new requests to the
-// current Instance.
+// current Instance. In dev mode, if the last reload failed, every request is
+// answered with an error page describing the failure instead.
 func (x *Server) Handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		x.Instance().ServeHTTP(w, r)
+		x.statusMutex.RLock()
+		reloadErr, sources := x.status.reloadErr, x.status.sources
+		x.statusMutex.RUnlock()
+		if sources != nil {
+			writeDevError(w, newDevError("Failed to load templates", reloadErr, sources, r, nil))
+			return
+		}
+
+		instance := x.Instance()
+		if instance == nil {
+			http.Error(w, "server stopped", http.StatusServiceUnavailable)
+			return
+		}
+		instance.ServeHTTP(w, r)
 	})
 }
 
 // Reload creates a new Instance from the config and swaps it with the
 // current instance if successful, otherwise returns the error.
 func (x *Server) Reload(cfgs ...Option) error {
-	start := time.Now()
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
 
+	_, err := x.reload(x.config, cfgs...)
+	return err
+}
+
+// ReloadConfig is like Reload, but it creates the new Instance from config
+// with cfgs applied to it. If successful, config replaces the Server's config
+// and is used by subsequent calls to Reload. The Ctx, Logger, Metrics, and
+// Tracer of the Server are retained and override the values in config.
+func (x *Server) ReloadConfig(config Config, cfgs ...Option) error {
 	x.mutex.Lock()
 	defer x.mutex.Unlock()
 
+	config.Ctx, config.Logger = x.config.Ctx, x.config.Logger
+	config.Metrics, config.Tracer = x.config.Metrics, x.config.Tracer
+	if _, err := config.Defaults().Options(cfgs...); err != nil {
+		return err
+	}
+	if _, err := x.reload(config); err != nil {
+		return err
+	}
+	x.config = config
+	return nil
+}
+
+// reload creates a new Instance from config, swaps it with the current
+// instance, and returns it. The caller must hold x.mutex.
+func (x *Server) reload(config Config, cfgs ...Option) (*Instance, error) {
+	start := time.Now()
+
 	log := x.config.Logger.WithGroup("reload")
 	old := x.instance.Load()
 	if old != nil {
@@ -84,14 +211,26 @@ func (x *Server) Reload(cfgs ...Option) error {
 	var new_ *Instance
 	{
 		var err error
-		config := x.config
+		var stats *InstanceStats
+		var routes []InstanceRoute
 		config.Ctx, newcancel = context.WithCancel(x.config.Ctx)
-		new_, _, _, err = config.Instance(cfgs...)
+		new_, stats, routes, err = config.instance(old, cfgs...)
 		if err != nil {
 			newcancel()
 			log.Info("failed to load", slog.Any("error", err), slog.Duration("rebuild_time", time.Since(start)))
-			return err
+			config.Metrics.observeReload(time.Since(start), err)
+			x.statusMutex.Lock()
+			x.status.reloadErr, x.status.reloadErrAt, x.status.reloadFailed = err, time.Now(), true
+			if config.Dev {
+				x.status.sources = loadSourceFunc(&config)
+			}
+			x.statusMutex.Unlock()
+			return nil, err
 		}
+		config.Metrics.observeReload(time.Since(start), nil)
+		x.statusMutex.Lock()
+		x.status = serverStatus{stats: stats, routes: routes, loadedAt: time.Now(), loadTime: time.Since(start), reloadErr: x.status.reloadErr, reloadErrAt: x.status.reloadErrAt}
+		x.statusMutex.Unlock()
 	}
 
 	x.instance.CompareAndSwap(old, new_)
@@ -99,18 +238,55 @@ func (x *Server) Reload(cfgs ...Option) error {
 		x.cancel()
 	}
 	x.cancel = newcancel
+	if old != nil {
+		x.retiring.Add(1)
+		go x.retire(old, x.config.Logger)
+	}
 
 	log.Info("rebuild succeeded", slog.Int64("new_id", new_.id), slog.Duration("rebuild_time", time.Since(start)))
-	return nil
+	return new_, nil
 }
 
+// Stop cancels the current Instance and blocks until it and all previously
+// retired instances have finished serving outstanding requests and shut down
+// their dot providers. After Stop returns, requests to Handler are answered
+// with 503 Service Unavailable until the next successful Reload.
 func (x *Server) Stop() {
 	x.mutex.Lock()
-	defer x.mutex.Unlock()
-
 	if x.cancel != nil {
 		x.cancel()
 	}
 	x.cancel = nil
-	x.instance.Store(nil)
+	if old := x.instance.Swap(nil); old != nil {
+		x.retiring.Add(1)
+		go x.retire(old, x.config.Logger)
+	}
+	x.mutex.Unlock()
+
+	// don't block reloads and the admin handler while requests drain
+	x.retiring.Wait()
+}
+
+// shutdownTimeout limits how long an http server is given to close its
+// connections, and how long dot providers of a retired instance are given to
+// shut down.
+const shutdownTimeout = 30 * time.Second
+
+// retire waits for the outstanding requests of an instance whose context has
+// been cancelled to complete, then shuts down its dot providers.
+func (x *Server) retire(old *Instance, log *slog.Logger) {
+	defer x.retiring.Done()
+	start := time.Now()
+	log = log.WithGroup("retire").With(slog.Int64("id", old.id))
+
+	log.Debug("draining instance", slog.Int64("active_requests", old.ActiveRequests()))
+	old.drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := old.Shutdown(ctx); err != nil {
+		log.Warn("failed to shut down instance", slog.Any("error", err), slog.Duration("retire_time", time.Since(start)))
+		return
+	}
+	log.Info("instance retired", slog.Duration("retire_time", time.Since(start)))
 }
@@ -0,0 +1,118 @@
+package xtemplate
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestServerShutdownOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := New()
+	config.Ctx = ctx
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"index.html": {Data: []byte(`hello`)},
+	}
+	server, err := config.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.ServeListeners(&http.Server{}, ln) }()
+
+	res, err := http.Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", body)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the server to shut down when its ctx is cancelled")
+	}
+	if server.Instance() != nil {
+		t.Errorf("expected the server to be stopped after shutting down")
+	}
+}
+
+func TestServerStopDrains(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	config := New()
+	config.Minify = false
+	config.FuncMaps = []template.FuncMap{{"wait": func() string {
+		started <- struct{}{}
+		<-release
+		return "done"
+	}}}
+	config.TemplatesFS = fstest.MapFS{
+		".slow.html": {Data: []byte(`{{define "GET /slow"}}{{wait}}{{end}}`)},
+	}
+	server, err := config.Server()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		server.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+		close(served)
+	}()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		server.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("expected Stop to wait for the in-flight request")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the server can be reloaded while the stopped instance drains
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- server.Reload() }()
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Reload not to block while Stop drains requests")
+	}
+
+	close(release)
+	<-served
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Stop to return after the in-flight request finished")
+	}
+	if w.Code != 200 || w.Body.String() != "done" {
+		t.Errorf("expected the in-flight request to complete, got %d %q", w.Code, w.Body)
+	}
+	server.Stop()
+}
@@ -0,0 +1,136 @@
+package xtemplate
+
+// Text templates are template files parsed with text/template instead of
+// html/template, for generating responses that are not html like xml feeds,
+// json, or csv. See Config.TextTemplateExtension.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/tdewolff/minify/v2"
+)
+
+// textContentTypes maps the extension of a text template file, after removing
+// the text template extension, to the content type of its responses. Other
+// extensions are looked up with the mime package.
+var textContentTypes = map[string]string{
+	".atom": "application/atom+xml",
+	".csv":  "text/csv; charset=utf-8",
+	".ics":  "text/calendar; charset=utf-8",
+	".json": "application/json",
+	".rss":  "application/rss+xml",
+	".txt":  "text/plain; charset=utf-8",
+	".xml":  "application/xml",
+}
+
+// textContentType returns the content type of responses from a text template
+// file served at routePath, defaulting to plain text.
+func textContentType(routePath string) string {
+	ext := strings.ToLower(path.Ext(routePath))
+	if ct, ok := textContentTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return "text/plain; charset=utf-8"
+}
+
+func (b *builder) addTextTemplateHandler(path_ string) error {
+	path_, newtemplates, err := b.parseTemplateFile(path_, nil)
+	if err != nil {
+		return err
+	}
+	b.TemplateFiles += 1
+
+	routePath := strings.TrimSuffix(path_, b.config.TextTemplateExtension)
+	contentType := textContentType(routePath)
+
+	for name, tree := range newtemplates {
+		if first, _, _ := strings.Cut(name, " "); slices.Contains(reservedTemplateKeywords, first) {
+			return fmt.Errorf("template '%s' in '%s' is only supported in html templates", name, path_)
+		}
+		if prev := b.textTemplates.Lookup(name); prev != nil && prev.Tree != nil {
+			b.duplicateDefinition(name, path_, prev.Tree.ParseName, tree)
+		}
+		tmpl, err := b.textTemplates.AddParseTree(name, tree)
+		if err != nil {
+			return fmt.Errorf("could not add template '%s' from '%s': %v", name, path_, err)
+		}
+		b.TemplateDefinitions += 1
+
+		var pattern string
+		var handler http.HandlerFunc
+		if name == path_ {
+			// don't register routes to hidden files
+			_, file := filepath.Split(path_)
+			if len(file) > 0 && file[0] == '.' {
+				continue
+			}
+			pattern = "GET " + routePath
+			handler = textTemplateHandler(b.Instance, tmpl, contentType)
+		} else if method, hostpath, ok := parseRouteName(name); ok {
+			if method == "SSE" {
+				pattern = "GET " + hostpath
+				handler = flushingTemplateHandler(b.Instance, tmpl)
+			} else {
+				pattern = method + " " + hostpath
+				// a route's own extension takes precedence over the file's
+				ct := contentType
+				if path.Ext(patternPath(pattern)) != "" {
+					ct = textContentType(patternPath(pattern))
+				}
+				handler = textTemplateHandler(b.Instance, tmpl, ct)
+			}
+		} else {
+			if looksLikeRoute.MatchString(name) {
+				b.config.Logger.Warn("template name looks like a route but is not a valid route pattern, expected 'METHOD [HOST]/PATH'", slog.String("name", name), slog.String("template_path", path_))
+			}
+			continue
+		}
+
+		b.routes = append(b.routes, InstanceRoute{Pattern: pattern, Handler: handler, Template: name, File: path_})
+		b.Routes += 1
+		b.config.Logger.Debug("added text template handler", slog.String("pattern", pattern), slog.String("content_type", contentType), slog.String("template_path", path_))
+	}
+	return nil
+}
+
+// textTemplateHandler executes the text template tmpl into a buffer and
+// writes it to the response with contentType, unless the template set a
+// different Content-Type header. The output is minified if the instance has a
+// minifier for its media type.
+func textTemplateHandler(server *Instance, tmpl *texttemplate.Template, contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+
+		buf := bufPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer bufPool.Put(buf)
+
+		if _, ok := server.executeBuffered(w, r, tmpl, buf); !ok {
+			return
+		}
+
+		out := buf.Bytes()
+		if server.textMinifier != nil && len(out) > 0 {
+			mediatype, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+			if minified, err := server.textMinifier.Bytes(mediatype, out); err == nil {
+				out = minified
+			} else if !errors.Is(err, minify.ErrNotExist) {
+				GetLogger(r.Context()).Warn("failed to minify template output", slog.String("content_type", mediatype), slog.Any("error", err))
+			}
+		}
+		w.Write(out)
+	}
+}
@@ -0,0 +1,419 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracer records spans for requests served by an [Instance] and the work done
+// by templates and dot providers while serving them, and exports them in
+// batches to a [SpanExporter]. Create one with [NewTracer] and add it to a
+// config with [WithTracer]. Incoming requests with a W3C `traceparent` header
+// continue the caller's trace. A nil *Tracer records nothing. Export errors
+// are logged with the Logger of the config that the Tracer was last used by.
+type Tracer struct {
+	exporter SpanExporter
+	log      atomic.Pointer[slog.Logger]
+
+	mutex   sync.Mutex
+	batch   []*Span
+	flush   chan struct{}
+	done    chan struct{}
+	stopped bool
+}
+
+// SpanExporter receives finished spans from a [Tracer]. See
+// [NewOTLPExporter] and [NewWriterExporter].
+type SpanExporter interface {
+	ExportSpans(context.Context, []*Span) error
+	Shutdown(context.Context) error
+}
+
+// Span is a named, timed operation within a trace.
+type Span struct {
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Name         string
+	// Server is true for the root span of a request served by an instance.
+	Server     bool
+	Start, End time.Time
+	Attributes map[string]any
+	Err        error
+
+	trace  *traceContext
+	parent *Span
+}
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+func (s SpanID) IsZero() bool    { return s == SpanID{} }
+
+const (
+	traceBatchSize     = 512
+	traceFlushInterval = 5 * time.Second
+)
+
+// NewTracer creates a Tracer that exports spans to exporter.
+func NewTracer(exporter SpanExporter) *Tracer {
+	t := &Tracer{exporter: exporter, flush: make(chan struct{}, 1), done: make(chan struct{})}
+	go t.run()
+	return t
+}
+
+// WithTracer creates an [xtemplate.Option] that records spans with t.
+func WithTracer(t *Tracer) Option {
+	return func(c *Config) error {
+		if t == nil {
+			return fmt.Errorf("nil tracer")
+		}
+		c.Tracer = t
+		return nil
+	}
+}
+
+// setLogger sets the logger that export errors are reported to.
+func (t *Tracer) setLogger(log *slog.Logger) {
+	if t != nil && log != nil {
+		t.log.Store(log)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case _, ok := <-t.flush:
+			if !ok {
+				t.export(context.Background())
+				return
+			}
+		}
+		t.export(context.Background())
+	}
+}
+
+func (t *Tracer) export(ctx context.Context) {
+	t.mutex.Lock()
+	batch := t.batch
+	t.batch = nil
+	t.mutex.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	if err := t.exporter.ExportSpans(ctx, batch); err != nil {
+		log := t.log.Load()
+		if log == nil {
+			log = slog.Default()
+		}
+		log.Warn("failed to export spans", slog.Int("spans", len(batch)), slog.Any("error", err))
+	}
+}
+
+func (t *Tracer) record(s *Span) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stopped {
+		return
+	}
+	t.batch = append(t.batch, s)
+	if len(t.batch) >= traceBatchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Shutdown exports any remaining spans and shuts down the exporter. Spans that
+// end after Shutdown is called are discarded.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	if t.stopped {
+		t.mutex.Unlock()
+		return nil
+	}
+	t.stopped = true
+	close(t.flush)
+	t.mutex.Unlock()
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// traceContext is attached to a request context and tracks the innermost open
+// span so that spans started while executing a template nest correctly.
+// Template execution for a request happens on a single goroutine.
+type traceContext struct {
+	tracer  *Tracer
+	current *Span
+}
+
+type traceType struct{}
+
+var traceKey = traceType{}
+
+// startTrace starts the root span for a request, continuing the trace from
+// the request's traceparent header if it is valid.
+func (t *Tracer) startTrace(ctx context.Context, name string, header http.Header) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	tc := &traceContext{tracer: t}
+	s := &Span{Name: name, Server: true, Start: time.Now(), Attributes: map[string]any{}, trace: tc}
+	if traceID, parentID, ok := parseTraceparent(header.Get("traceparent")); ok {
+		s.TraceID, s.ParentSpanID = traceID, parentID
+	} else {
+		rand.Read(s.TraceID[:])
+	}
+	rand.Read(s.SpanID[:])
+	tc.current = s
+	return context.WithValue(ctx, traceKey, tc), s
+}
+
+// startSpan starts a span as a child of the innermost open span in ctx. It
+// returns nil if ctx is not being traced.
+func startSpan(ctx context.Context, name string, attrs ...any) *Span {
+	tc, _ := ctx.Value(traceKey).(*traceContext)
+	if tc == nil || tc.current == nil {
+		return nil
+	}
+	parent := tc.current
+	s := &Span{
+		TraceID:      parent.TraceID,
+		ParentSpanID: parent.SpanID,
+		Name:         name,
+		Start:        time.Now(),
+		Attributes:   map[string]any{},
+		trace:        tc,
+		parent:       parent,
+	}
+	rand.Read(s.SpanID[:])
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.Attributes[fmt.Sprint(attrs[i])] = attrs[i+1]
+	}
+	tc.current = s
+	return s
+}
+
+// SetAttr sets an attribute on the span.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// end finishes the span with an optional error and queues it for export.
+func (s *Span) end(err error) {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	s.Err = err
+	if s.trace.current == s {
+		s.trace.current = s.parent
+	}
+	s.trace.tracer.record(s)
+}
+
+// traceparent formats the W3C traceparent header value that identifies s as
+// the parent of a downstream request.
+func (s *Span) traceparent() string {
+	return "00-" + s.TraceID.String() + "-" + s.SpanID.String() + "-01"
+}
+
+// currentTraceparent returns the traceparent header value for the innermost
+// open span in ctx, or an empty string if ctx is not being traced.
+func currentTraceparent(ctx context.Context) string {
+	tc, _ := ctx.Value(traceKey).(*traceContext)
+	if tc == nil || tc.current == nil {
+		return ""
+	}
+	return tc.current.traceparent()
+}
+
+func parseTraceparent(h string) (traceID TraceID, parentID SpanID, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || traceID == (TraceID{}) {
+		return
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || parentID.IsZero() {
+		return
+	}
+	return traceID, parentID, true
+}
+
+// NewWriterExporter creates a [SpanExporter] that writes each span to w as a
+// line of json. Useful for local testing.
+func NewWriterExporter(w io.Writer) SpanExporter {
+	return &writerExporter{w: w}
+}
+
+type writerExporter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (e *writerExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		var errstr string
+		if s.Err != nil {
+			errstr = s.Err.Error()
+		}
+		var parent string
+		if !s.ParentSpanID.IsZero() {
+			parent = s.ParentSpanID.String()
+		}
+		if err := enc.Encode(map[string]any{
+			"trace_id":       s.TraceID.String(),
+			"span_id":        s.SpanID.String(),
+			"parent_span_id": parent,
+			"name":           s.Name,
+			"start":          s.Start,
+			"duration":       s.End.Sub(s.Start).String(),
+			"attributes":     s.Attributes,
+			"error":          errstr,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *writerExporter) Shutdown(context.Context) error {
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// NewOTLPExporter creates a [SpanExporter] that sends spans to an
+// OpenTelemetry collector with the OTLP/HTTP protocol using json encoding.
+// endpoint is the full url of the traces endpoint, typically ending in
+// `/v1/traces`. headers are added to each export request.
+func NewOTLPExporter(endpoint, serviceName string, headers map[string]string) SpanExporter {
+	return &otlpExporter{endpoint: endpoint, serviceName: serviceName, headers: headers, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type otlpExporter struct {
+	endpoint, serviceName string
+	headers               map[string]string
+	client                *http.Client
+}
+
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	otlpSpans := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		span := map[string]any{
+			"traceId":           s.TraceID.String(),
+			"spanId":            s.SpanID.String(),
+			"name":              s.Name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.Attributes),
+		}
+		if s.Server {
+			span["kind"] = 2 // SPAN_KIND_SERVER
+		}
+		if !s.ParentSpanID.IsZero() {
+			span["parentSpanId"] = s.ParentSpanID.String()
+		}
+		if s.Err != nil {
+			span["status"] = map[string]any{"code": 2, "message": s.Err.Error()} // STATUS_CODE_ERROR
+		}
+		otlpSpans = append(otlpSpans, span)
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(map[string]any{"service.name": e.serviceName})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/infogulch/xtemplate"},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to export spans: collector responded with status %s", res.Status)
+	}
+	return nil
+}
+
+func (e *otlpExporter) Shutdown(context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+func otlpAttributes(attrs map[string]any) []any {
+	out := make([]any, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": k, "value": value})
+	}
+	return out
+}
@@ -0,0 +1,129 @@
+package xtemplate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", true},
+		// future versions may append fields
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		traceID, parentID, ok := parseTraceparent(test.header)
+		if ok != test.ok {
+			t.Errorf("parseTraceparent(%q): expected ok=%v, got %v", test.header, test.ok, ok)
+			continue
+		}
+		if ok && (traceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || parentID.String() != "00f067aa0ba902b7") {
+			t.Errorf("parseTraceparent(%q): got trace %s parent %s", test.header, traceID, parentID)
+		}
+	}
+}
+
+type sliceExporter struct {
+	mutex sync.Mutex
+	spans []*Span
+}
+
+func (e *sliceExporter) ExportSpans(_ context.Context, spans []*Span) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *sliceExporter) Shutdown(context.Context) error { return nil }
+
+func TestTracing(t *testing.T) {
+	exporter := &sliceExporter{}
+	tracer := NewTracer(exporter)
+	config := New()
+	config.Minify = false
+	config.TemplatesFS = fstest.MapFS{
+		"index.html": {Data: []byte(`{{define "part"}}part{{end}}{{.X.Template "part" .}}`)},
+	}
+	instance, _, _, err := config.Instance(WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	instance.ServeHTTP(httptest.NewRecorder(), r)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]*Span{}
+	for _, s := range exporter.spans {
+		spans[s.Name] = s
+	}
+	root, child := spans["GET /"], spans["X.Template"]
+	if root == nil || child == nil {
+		t.Fatalf("expected request and X.Template spans, got %v", spans)
+	}
+	if root.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || root.ParentSpanID.String() != "00f067aa0ba902b7" || !root.Server {
+		t.Errorf("expected the request span to continue the caller's trace, got trace %s parent %s", root.TraceID, root.ParentSpanID)
+	}
+	if child.TraceID != root.TraceID || child.ParentSpanID != root.SpanID {
+		t.Errorf("expected X.Template span to be a child of the request span")
+	}
+	if root.Attributes["http.route"] != "GET /" || child.Attributes["xtemplate.template"] != "part" {
+		t.Errorf("unexpected attributes %v %v", root.Attributes, child.Attributes)
+	}
+}
+
+type failingExporter struct{}
+
+func (failingExporter) ExportSpans(context.Context, []*Span) error {
+	return errors.New("collector unavailable")
+}
+
+func (failingExporter) Shutdown(context.Context) error { return nil }
+
+func TestTracingExportError(t *testing.T) {
+	var logs bytes.Buffer
+	tracer := NewTracer(failingExporter{})
+	config := New()
+	config.Minify = false
+	config.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	config.TemplatesFS = fstest.MapFS{
+		"index.html": {Data: []byte(`home`)},
+	}
+	instance, _, _, err := config.Instance(WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instance.Shutdown(config.Ctx)
+
+	instance.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := logs.String(); !strings.Contains(got, "failed to export spans") || !strings.Contains(got, "collector unavailable") {
+		t.Errorf("expected the export error to be logged, got %q", got)
+	}
+}
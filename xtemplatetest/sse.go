@@ -0,0 +1,184 @@
+package xtemplatetest
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a server-sent event received from an SSE route.
+type Event struct {
+	Event string
+	// Data is the data of the event. Multiple `data:` lines are joined with
+	// newlines.
+	Data  string
+	ID    string
+	Retry string
+}
+
+// EventStream is a connection to an SSE route that is being served by the
+// instance in the background. Read events with Next, and call Close to cancel
+// the request. The stream is closed when the test finishes.
+type EventStream struct {
+	// StatusCode and Header are the status and headers of the response.
+	StatusCode int
+	Header     http.Header
+
+	h      *Harness
+	req    *http.Request
+	events chan Event
+	cancel context.CancelFunc
+	reader *io.PipeReader
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// SSE connects to the SSE route at target, see [Request.Stream].
+func (h *Harness) SSE(target string) *EventStream {
+	h.tb.Helper()
+	return h.Get(target).Stream()
+}
+
+// Stream sends the request with `Accept: text/event-stream` and returns the
+// event stream once the response headers are written. Unlike Do, the request
+// keeps running in the background until the handler returns or the stream is
+// closed.
+func (r *Request) Stream() *EventStream {
+	r.h.tb.Helper()
+	ctx, cancel := context.WithCancel(r.req.Context())
+	req := r.req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	for _, c := range r.h.jar.Cookies(cookieURL(req)) {
+		req.AddCookie(c)
+	}
+
+	pr, pw := io.Pipe()
+	w := &streamWriter{header: http.Header{}, body: pw, ready: make(chan struct{})}
+	s := &EventStream{
+		h:      r.h,
+		req:    req,
+		events: make(chan Event),
+		cancel: cancel,
+		reader: pr,
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		r.h.Instance.ServeHTTP(w, req)
+		w.WriteHeader(http.StatusOK)
+		pw.Close()
+	}()
+	go s.read()
+	r.h.tb.Cleanup(s.Close)
+
+	<-w.ready
+	s.StatusCode, s.Header = w.status, w.snapshot
+	r.h.jar.SetCookies(cookieURL(req), (&http.Response{Header: s.Header}).Cookies())
+	return s
+}
+
+// read parses events from the response body as they are written and sends
+// them to s.events until the body ends or the stream is closed.
+func (s *EventStream) read() {
+	defer close(s.events)
+	var e Event
+	var data []string
+	pending := false
+	scanner := bufio.NewScanner(s.reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if !pending {
+				continue
+			}
+			e.Data = strings.Join(data, "\n")
+			select {
+			case s.events <- e:
+			case <-s.closed:
+				return
+			}
+			e, data, pending = Event{}, nil, false
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			// comment
+			continue
+		case "event":
+			e.Event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			e.ID = value
+		case "retry":
+			e.Retry = value
+		default:
+			continue
+		}
+		pending = true
+	}
+}
+
+// Next returns the next event, or false if the response ended without
+// sending another event. It fails the test if no event is received within
+// timeout.
+func (s *EventStream) Next(timeout time.Duration) (Event, bool) {
+	s.h.tb.Helper()
+	select {
+	case e, ok := <-s.events:
+		return e, ok
+	case <-time.After(timeout):
+		s.h.tb.Fatalf("%s %s: no event received within %s", s.req.Method, s.req.URL, timeout)
+		return Event{}, false
+	}
+}
+
+// Close cancels the request and waits for the handler to return.
+func (s *EventStream) Close() {
+	s.once.Do(func() {
+		s.cancel()
+		close(s.closed)
+		s.reader.CloseWithError(io.ErrClosedPipe)
+		<-s.done
+	})
+}
+
+// streamWriter is an [http.ResponseWriter] that passes the body through a
+// pipe as it is written, so events can be read while the handler runs.
+type streamWriter struct {
+	header   http.Header
+	snapshot http.Header
+	status   int
+	body     *io.PipeWriter
+	ready    chan struct{}
+	once     sync.Once
+}
+
+func (w *streamWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *streamWriter) WriteHeader(code int) {
+	w.once.Do(func() {
+		w.status, w.snapshot = code, w.header.Clone()
+		close(w.ready)
+	})
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// Flush is a no-op because writes to the pipe are unbuffered.
+func (w *streamWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
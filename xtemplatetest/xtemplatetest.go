@@ -0,0 +1,241 @@
+// Package xtemplatetest helps Go programs that embed xtemplate test their
+// templates in-process with the standard testing package.
+//
+// Build a [Harness] from templates in an [fstest.MapFS] or any other fs.FS,
+// then issue requests and assert on the responses with its fluent API:
+//
+//	func TestContact(t *testing.T) {
+//		h := xtemplatetest.New(t, fstest.MapFS{
+//			"contact.html": {Data: []byte(`<h1>{{.DB.QueryVal "SELECT 'Ann'"}}</h1>`)},
+//		}, xtemplatetest.WithMemoryDB("DB"))
+//		h.Get("/contact").Do().
+//			Status(200).
+//			BodyContains("<h1>Ann</h1>").
+//			Golden("contact")
+//	}
+//
+// Requests don't use the network. Cookies set by responses are sent with later
+// requests made by the same harness, and redirects can be followed. Events
+// sent by SSE routes are read as they are flushed with [Harness.SSE].
+package xtemplatetest
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/infogulch/xtemplate"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+var update = flag.Bool("update-golden", false, "update the golden files compared by xtemplatetest.Response.Golden")
+
+// Harness is an [xtemplate.Instance] under test.
+type Harness struct {
+	tb testing.TB
+
+	Instance *xtemplate.Instance
+	Stats    *xtemplate.InstanceStats
+	Routes   []xtemplate.InstanceRoute
+
+	jar http.CookieJar
+}
+
+// New builds an instance that loads templates from fsys configured with
+// options, and fails the test immediately if it can't be built. The instance
+// is shut down when the test finishes.
+func New(tb testing.TB, fsys fs.FS, options ...xtemplate.Option) *Harness {
+	tb.Helper()
+	config := xtemplate.New()
+	config.TemplatesFS = fsys
+	config.Minify = false
+	instance, stats, routes, err := config.Instance(options...)
+	if err != nil {
+		tb.Fatalf("failed to build xtemplate instance: %v", err)
+	}
+	tb.Cleanup(func() { instance.Shutdown(config.Ctx) })
+	jar, _ := cookiejar.New(nil)
+	return &Harness{tb: tb, Instance: instance, Stats: stats, Routes: routes, jar: jar}
+}
+
+// WithMemoryDB adds a database dot field named name that is backed by a new,
+// empty, in-memory sqlite database, see [xtemplate.MemoryDBConnstr]. The
+// program must register the sqlite3 driver, e.g. by importing
+// github.com/mattn/go-sqlite3.
+func WithMemoryDB(name string) xtemplate.Option {
+	return func(c *xtemplate.Config) error {
+		c.Databases = append(c.Databases, xtemplate.DotDBConfig{Name: name, Driver: "sqlite3", Connstr: xtemplate.MemoryDBConnstr()})
+		return nil
+	}
+}
+
+// WithMemoryNATS adds a nats dot field named name that is connected to a new
+// in-process nats server with JetStream enabled, which stores its data in a
+// temporary directory that is removed when the test finishes.
+func WithMemoryNATS(tb testing.TB, name string) xtemplate.Option {
+	return xtemplate.WithNats(name, &server.Options{DontListen: true, JetStream: true, StoreDir: tb.TempDir()}, nil, nil)
+}
+
+// WithFS adds a directory dot field named name that reads files from fsys,
+// like an [fstest.MapFS].
+func WithFS(name string, fsys fs.FS) xtemplate.Option {
+	return xtemplate.WithDir(name, fsys)
+}
+
+// Request is a request being prepared by a [Harness]. Call Do to send it.
+type Request struct {
+	h      *Harness
+	req    *http.Request
+	follow bool
+}
+
+// Get prepares a GET request for target, which is a path with an optional
+// query string.
+func (h *Harness) Get(target string) *Request {
+	return h.NewRequest("GET", target, nil)
+}
+
+// Post prepares a POST request for target with a url encoded form body.
+func (h *Harness) Post(target string, form url.Values) *Request {
+	return h.NewRequest("POST", target, strings.NewReader(form.Encode())).
+		Header("Content-Type", "application/x-www-form-urlencoded")
+}
+
+// NewRequest prepares a request with any method and body.
+func (h *Harness) NewRequest(method, target string, body io.Reader) *Request {
+	return &Request{h: h, req: httptest.NewRequest(method, target, body)}
+}
+
+// Header adds a request header.
+func (r *Request) Header(name, value string) *Request {
+	r.req.Header.Add(name, value)
+	return r
+}
+
+// HTMX marks the request as an htmx request that targets the element with
+// the given id, to render a fragment of a page.
+func (r *Request) HTMX(target string) *Request {
+	return r.Header("HX-Request", "true").Header("HX-Target", target)
+}
+
+// FollowRedirects makes Do follow up to 10 redirects with GET requests and
+// return the final response.
+func (r *Request) FollowRedirects() *Request {
+	r.follow = true
+	return r
+}
+
+// Do sends the request to the instance and returns the response.
+func (r *Request) Do() *Response {
+	r.h.tb.Helper()
+	req := r.req
+	for redirects := 0; ; redirects++ {
+		res := r.h.serve(req)
+		location := res.Header.Get("Location")
+		if !r.follow || res.StatusCode < 300 || res.StatusCode >= 400 || location == "" {
+			return res
+		}
+		if redirects == 10 {
+			r.h.tb.Fatalf("stopped after 10 redirects, last to %s", location)
+		}
+		next, err := req.URL.Parse(location)
+		if err != nil {
+			r.h.tb.Fatalf("invalid redirect location '%s': %v", location, err)
+		}
+		req = httptest.NewRequest("GET", next.String(), nil)
+	}
+}
+
+// serve sends req to the instance with the harness's cookies and records the
+// cookies that the response sets.
+func (h *Harness) serve(req *http.Request) *Response {
+	for _, c := range h.jar.Cookies(cookieURL(req)) {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	h.Instance.ServeHTTP(w, req)
+	res := w.Result()
+	res.Request = req
+	h.jar.SetCookies(cookieURL(req), res.Cookies())
+	body, _ := io.ReadAll(res.Body)
+	return &Response{Response: res, Body: string(body), tb: h.tb}
+}
+
+// cookieURL returns the absolute url of req, which identifies its cookies in
+// the jar. Server requests only have a path in their URL.
+func cookieURL(req *http.Request) *url.URL {
+	return &url.URL{Scheme: "http", Host: req.Host, Path: req.URL.Path}
+}
+
+// Response is a response from the instance. Its assertion methods report
+// failures with t.Errorf and return the response to allow chaining.
+type Response struct {
+	*http.Response
+	// Body is the complete response body.
+	Body string
+
+	tb testing.TB
+}
+
+// Status asserts that the response has the status code.
+func (r *Response) Status(code int) *Response {
+	r.tb.Helper()
+	if r.StatusCode != code {
+		r.tb.Errorf("%s %s: expected status %d, got %d; body:\n%s", r.Request.Method, r.Request.URL, code, r.StatusCode, r.Body)
+	}
+	return r
+}
+
+// HeaderEquals asserts that the first value of the response header name is
+// value.
+func (r *Response) HeaderEquals(name, value string) *Response {
+	r.tb.Helper()
+	if got := r.Header.Get(name); got != value {
+		r.tb.Errorf("%s %s: expected header %s to be %q, got %q", r.Request.Method, r.Request.URL, name, value, got)
+	}
+	return r
+}
+
+// BodyContains asserts that the response body contains s.
+func (r *Response) BodyContains(s string) *Response {
+	r.tb.Helper()
+	if !strings.Contains(r.Body, s) {
+		r.tb.Errorf("%s %s: expected body to contain %q; body:\n%s", r.Request.Method, r.Request.URL, s, r.Body)
+	}
+	return r
+}
+
+// Golden asserts that the response body is identical to the golden file
+// `testdata/<name>.golden`. Run the test with `-update-golden` to write the
+// current body to the file instead.
+func (r *Response) Golden(name string) *Response {
+	r.tb.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			r.tb.Fatalf("failed to create golden file directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(r.Body), 0o644); err != nil {
+			r.tb.Fatalf("failed to update golden file: %v", err)
+		}
+		return r
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		r.tb.Errorf("failed to read golden file, run with -update-golden to create it: %v", err)
+		return r
+	}
+	if !bytes.Equal(want, []byte(r.Body)) {
+		r.tb.Errorf("%s %s: body does not match golden file %s, run with -update-golden to update it\n--- want\n%s\n--- got\n%s", r.Request.Method, r.Request.URL, path, want, r.Body)
+	}
+	return r
+}
@@ -0,0 +1,121 @@
+package xtemplatetest
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestHarness(t *testing.T) {
+	h := New(t, fstest.MapFS{
+		"contact.html": {Data: []byte(`<h1>{{.DB.QueryVal "SELECT 'Ann'"}}</h1>`)},
+		"form.html":    {Data: []byte(`{{define "POST /form"}}{{.Req.ParseForm}}{{.Req.PostForm.Get "name"}}{{end}}`)},
+		"frag.html":    {Data: []byte(`<main>{{block "box" .}}<div id="box">box</div>{{end}}</main>`)},
+	}, WithMemoryDB("DB"))
+
+	h.Get("/contact").Do().
+		Status(200).
+		BodyContains("<h1>Ann</h1>")
+	h.Post("/form", url.Values{"name": {"Bob"}}).Do().
+		Status(200).
+		BodyContains("Bob")
+	if res := h.Get("/frag").HTMX("box").Do().Status(200); strings.Contains(res.Body, "<main>") {
+		t.Errorf("expected only the fragment, got: %s", res.Body)
+	}
+	h.Get("/missing").Do().Status(404)
+}
+
+func TestFollowRedirects(t *testing.T) {
+	h := New(t, fstest.MapFS{
+		"old.html":   {Data: []byte(`{{.Resp.SetHeader "Location" "/older"}}{{.Resp.SetStatus 303}}`)},
+		"older.html": {Data: []byte(`{{.Resp.SetHeader "Location" "new"}}{{.Resp.SetStatus 302}}`)},
+		"new.html":   {Data: []byte(`new page`)},
+	})
+
+	h.Get("/old").Do().
+		Status(303).
+		HeaderEquals("Location", "/older")
+	res := h.Get("/old").FollowRedirects().Do().
+		Status(200).
+		BodyContains("new page")
+	if res.Request.URL.Path != "/new" {
+		t.Errorf("expected final request to /new, got %s", res.Request.URL.Path)
+	}
+}
+
+func TestCookies(t *testing.T) {
+	h := New(t, fstest.MapFS{
+		"login.html": {Data: []byte(`{{.Resp.AddHeader "Set-Cookie" "session=abc; Path=/"}}ok`)},
+		"me.html":    {Data: []byte(`{{with .Req.Cookie "session"}}{{.Value}}{{end}}`)},
+	})
+
+	h.Get("/me").Do().Status(500)
+	h.Get("/login").Do().Status(200)
+	h.Get("/me").Do().Status(200).BodyContains("abc")
+}
+
+// recorder is a testing.TB that records failures instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, format)
+}
+
+func TestGolden(t *testing.T) {
+	h := New(t, fstest.MapFS{
+		"page.html":  {Data: []byte(`<p>{{"golden" | upper}}</p>`)},
+		"other.html": {Data: []byte(`<p>other</p>`)},
+	})
+
+	h.Get("/page").Do().Golden("page")
+
+	rec := &recorder{TB: t}
+	res := h.Get("/other").Do()
+	res.tb = rec
+	res.Golden("page")
+	res.Golden("does-not-exist")
+	if len(rec.errors) != 2 {
+		t.Errorf("expected golden mismatch and missing file to fail, got %d failures", len(rec.errors))
+	}
+}
+
+func TestSSE(t *testing.T) {
+	h := New(t, fstest.MapFS{
+		"stream.html": {Data: []byte(`
+{{- define "SSE /events"}}{{.Flush.SendSSE "greeting" "hello\nworld" "1"}}{{.Flush.SendSSE "" "bye"}}{{end}}
+{{- define "SSE /forever"}}{{.Flush.SendSSE "" "hi"}}{{.Flush.WaitForServerStop}}{{end}}`)},
+	})
+
+	s := h.SSE("/events")
+	if s.StatusCode != 200 || s.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got status %d and content type %q", s.StatusCode, s.Header.Get("Content-Type"))
+	}
+	if e, ok := s.Next(time.Second); !ok || e != (Event{Event: "greeting", Data: "hello\nworld", ID: "1"}) {
+		t.Errorf("unexpected first event %#v", e)
+	}
+	if e, ok := s.Next(time.Second); !ok || e != (Event{Data: "bye"}) {
+		t.Errorf("unexpected second event %#v", e)
+	}
+	if e, ok := s.Next(time.Second); ok {
+		t.Errorf("expected the stream to end, got %#v", e)
+	}
+
+	s = h.SSE("/forever")
+	if e, _ := s.Next(time.Second); e.Data != "hi" {
+		t.Errorf("unexpected event %#v", e)
+	}
+	s.Close()
+
+	if res := h.Get("/events").Do(); res.StatusCode != 406 {
+		t.Errorf("expected a request without Accept: text/event-stream to be rejected, got %d", res.StatusCode)
+	}
+}